@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/spf13/cobra"
+
+	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
+)
+
+var deregisterCmd = &cobra.Command{
+	Use: "deregister",
+	Short: "remove the agent from central and clear its registration",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		err := AgentServerConfigSetup()
+		if err != nil {
+			return fmt.Errorf("failed to setup agent server config: %w", err)
+		}
+
+		config := NewAgentConfig()
+		// the agent only accepts deregistration from loopback
+		addr := fmt.Sprintf("http://localhost%s/deregister", config.HTTPSPort)
+		rasp, err := http.Post(addr, "application/json", nil)
+		if err != nil {
+			return err
+		}
+		defer rasp.Body.Close()
+		if rasp.StatusCode != http.StatusOK {
+			txt, _ := io.ReadAll(rasp.Body)
+			return fmt.Errorf("failed to deregister, status code: %d, %s", rasp.StatusCode, txt)
+		}
+		fmt.Println("Agent deregistered, restart it to enroll again")
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deregisterCmd)
+}
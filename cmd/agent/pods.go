@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/spf13/cobra"
 
@@ -12,6 +13,8 @@ import (
 	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
 )
 
+var podsNamespace string
+
 var podsCmd = &cobra.Command{
 	Use: "pods",
 	Short: "list pods of the agent",
@@ -21,7 +24,12 @@ var podsCmd = &cobra.Command{
 		if config.Addr == ADDR_PLACEHOLDER {
 			return fmt.Errorf(AGENT_CONFIG_NOT_SET)
 		}
-		addr := fmt.Sprintf("http://%s%s/pods/list", config.Addr, config.HTTPSPort)
+		addr := fmt.Sprintf(
+			"http://%s%s/pods/list?namespace=%s",
+			config.Addr,
+			config.HTTPSPort,
+			url.QueryEscape(podsNamespace),
+		)
 		rasp, err := http.Get(addr)
 		if err != nil {
 			return err
@@ -54,5 +62,6 @@ var podsCmd = &cobra.Command{
 }
 
 func init() {
+	podsCmd.Flags().StringVarP(&podsNamespace, "namespace", "n", "", "namespace to list, \"*\" for all allowed namespaces")
 	rootCmd.AddCommand(podsCmd)
 }
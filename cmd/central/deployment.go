@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+)
+
+var (
+	deploymentNamespace string
+	deploymentReplicas  int32
+)
+
+var deploymentCmd = &cobra.Command{
+	Use: "deployment",
+	Short: "operate a deployment of an agent",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// Posts to /api/v1/agent/{agent_id}/deployments/{name}/{action} and decodes
+// the answer into out.
+func postDeploymentAction(agentID string, name string, action string, body any, out any) error {
+	cfg := GetCentralConfig()
+	addr := fmt.Sprintf(
+		"http://localhost%s/api/v1/agent/%s/deployments/%s/%s?namespace=%s",
+		cfg.HTTPSPort,
+		agentID,
+		name,
+		action,
+		url.QueryEscape(deploymentNamespace),
+	)
+	var reader io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(jsonBody)
+	}
+	rasp, err := http.Post(addr, "application/json", reader)
+	if err != nil {
+		return err
+	}
+	defer rasp.Body.Close()
+	if rasp.StatusCode != http.StatusOK {
+		txt, _ := io.ReadAll(rasp.Body)
+		return fmt.Errorf("failed to %s deployment, status code: %d, %s", action, rasp.StatusCode, txt)
+	}
+	if err := json.NewDecoder(rasp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+var deploymentScaleCmd = &cobra.Command{
+	Use: "scale <agent_id> <name>",
+	Short: "set the desired replicas of a deployment",
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("replicas") {
+			return fmt.Errorf("--replicas is required")
+		}
+		var result struct {
+			PreviousReplicas int32 `json:"previous_replicas"`
+			Replicas         int32 `json:"replicas"`
+		}
+		body := map[string]int32{"replicas": deploymentReplicas}
+		if err := postDeploymentAction(args[0], args[1], "scale", body, &result); err != nil {
+			return err
+		}
+		fmt.Printf("%s scaled from %d to %d replicas\n", args[1], result.PreviousReplicas, result.Replicas)
+		return nil
+	},
+}
+
+var deploymentRestartCmd = &cobra.Command{
+	Use: "restart <agent_id> <name>",
+	Short: "rolling restart of every pod of a deployment",
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var result struct {
+			RestartedAt string `json:"restarted_at"`
+		}
+		if err := postDeploymentAction(args[0], args[1], "restart", nil, &result); err != nil {
+			return err
+		}
+		fmt.Printf("%s restarted at %s\n", args[1], result.RestartedAt)
+		return nil
+	},
+}
+
+// pause and resume only differ by the action
+func pausedCmd(action string, short string) *cobra.Command {
+	return &cobra.Command{
+		Use: action + " <agent_id> <name>",
+		Short: short,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var result struct {
+				Changed bool `json:"changed"`
+			}
+			if err := postDeploymentAction(args[0], args[1], action, nil, &result); err != nil {
+				return err
+			}
+			if !result.Changed {
+				fmt.Printf("%s: nothing to %s\n", args[1], action)
+				return nil
+			}
+			fmt.Printf("%s: %sd\n", args[1], action)
+			return nil
+		},
+	}
+}
+
+func init() {
+	deploymentCmd.PersistentFlags().StringVarP(&deploymentNamespace, "namespace", "n", "", "namespace of the deployment, the agent's default namespace when empty")
+	deploymentScaleCmd.Flags().Int32Var(&deploymentReplicas, "replicas", 0, "desired replicas")
+	deploymentCmd.AddCommand(deploymentScaleCmd)
+	deploymentCmd.AddCommand(deploymentRestartCmd)
+	deploymentCmd.AddCommand(pausedCmd("pause", "stop rolling out pod template changes of a deployment"))
+	deploymentCmd.AddCommand(pausedCmd("resume", "resume the rollout of a paused deployment"))
+	rootCmd.AddCommand(deploymentCmd)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+)
+
+var tokenTTL time.Duration
+
+var tokenCmd = &cobra.Command{
+	Use: "token",
+	Short: "manage agent bootstrap tokens",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use: "create",
+	Short: "issue a single-use bootstrap token for agent enrollment",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg := GetCentralConfig()
+		addr := fmt.Sprintf("http://localhost%s/api/v1/tokens", cfg.HTTPSPort)
+		body, err := json.Marshal(map[string]string{
+			"ttl": tokenTTL.String(),
+		})
+		if err != nil {
+			return err
+		}
+		rasp, err := http.Post(addr, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer rasp.Body.Close()
+		if rasp.StatusCode != http.StatusOK {
+			txt, _ := io.ReadAll(rasp.Body)
+			return fmt.Errorf("failed to create token, status code: %d, %s", rasp.StatusCode, txt)
+		}
+
+		var token struct {
+			Token     string `json:"token"`
+			ExpiresAt int64  `json:"expires_at"`
+		}
+		if err := json.NewDecoder(rasp.Body).Decode(&token); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		fmt.Println(token.Token)
+		fmt.Printf("expires at %s\n", time.Unix(token.ExpiresAt, 0).Format(time.RFC3339))
+
+		return nil
+	},
+}
+
+func init() {
+	tokenCreateCmd.Flags().DurationVar(&tokenTTL, "ttl", time.Hour, "how long the token stays valid")
+	tokenCmd.AddCommand(tokenCreateCmd)
+	rootCmd.AddCommand(tokenCmd)
+}
@@ -0,0 +1,177 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+// Server-side applies the manifests in order, whatever their kind.
+// Namespaced objects without a namespace go to the given namespace,
+// allowNamespace is asked for every target namespace and gets
+// metav1.NamespaceAll for cluster-scoped objects.
+// Returns the result of every object along with the file it came from,
+// files that could not be read get a single failed result.
+func ApplyManifests(
+	ctx context.Context,
+	dyn dynamic.Interface,
+	mapper *restmapper.DeferredDiscoveryRESTMapper,
+	namespace string,
+	allowNamespace func(string) bool,
+	manifests []Manifest,
+) []*pba.ObjectResult {
+	results := []*pba.ObjectResult{}
+	applied := 0
+	for _, m := range manifests {
+		if m.Err != nil {
+			log.Errorf("Failed to read %s: %v", m.File, m.Err)
+			results = append(results, &pba.ObjectResult{
+				File:   proto.String(m.File),
+				Status: pba.ObjectStatus_FAILED.Enum(),
+				Error:  proto.String(m.Err.Error()),
+			})
+			continue
+		}
+
+		obj := m.Object
+		result := &pba.ObjectResult{
+			File:       proto.String(m.File),
+			ApiVersion: proto.String(obj.GetAPIVersion()),
+			Kind:       proto.String(obj.GetKind()),
+			Name:       proto.String(obj.GetName()),
+		}
+		live, changed, err := ApplyObject(ctx, dyn, mapper, namespace, allowNamespace, obj)
+		// namespace is settled by ApplyObject
+		result.Namespace = proto.String(obj.GetNamespace())
+		switch {
+		case err != nil:
+			log.Errorf("Failed to apply %s %s from %s: %v", obj.GetKind(), obj.GetName(), m.File, err)
+			result.Status = pba.ObjectStatus_FAILED.Enum()
+			result.Error = proto.String(err.Error())
+		case changed:
+			applied++
+			result.Status = pba.ObjectStatus_APPLIED.Enum()
+			result.ResourceVersion = proto.String(live.GetResourceVersion())
+		default:
+			result.Status = pba.ObjectStatus_UNCHANGED.Enum()
+			result.ResourceVersion = proto.String(live.GetResourceVersion())
+		}
+		results = append(results, result)
+	}
+	log.Infof("Applied %d of %d objects", applied, len(manifests))
+	return results
+}
+
+// Server-side applies a single object.
+// Returns the object as stored, and whether the apply changed it.
+func ApplyObject(
+	ctx context.Context,
+	dyn dynamic.Interface,
+	mapper *restmapper.DeferredDiscoveryRESTMapper,
+	namespace string,
+	allowNamespace func(string) bool,
+	obj *unstructured.Unstructured,
+) (*unstructured.Unstructured, bool, error) {
+	resource, err := prepareObject(dyn, mapper, namespace, allowNamespace, obj)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// an apply that changes nothing keeps the resourceVersion
+	before := ""
+	current, err := resource.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err == nil {
+		before = current.GetResourceVersion()
+	} else if !apierrors.IsNotFound(err) {
+		return nil, false, err
+	}
+
+	live, err := resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{
+		FieldManager: FIELD_MANAGER,
+		Force:        true,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	changed := live.GetResourceVersion() != before
+	if changed {
+		log.Infof("Applied %s %s", obj.GetKind(), ObjectKey(obj))
+	}
+	return live, changed, nil
+}
+
+// Checks the object can be applied on this agent and resolves where to.
+func prepareObject(
+	dyn dynamic.Interface,
+	mapper *restmapper.DeferredDiscoveryRESTMapper,
+	namespace string,
+	allowNamespace func(string) bool,
+	obj *unstructured.Unstructured,
+) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	if gvk.Kind == "" || gvk.Version == "" {
+		return nil, fmt.Errorf("object has no apiVersion or kind")
+	}
+	if obj.GetName() == "" {
+		return nil, fmt.Errorf("%s has no name", gvk.Kind)
+	}
+
+	resource, err := resourceFor(dyn, mapper, namespace, obj)
+	if err != nil {
+		return nil, err
+	}
+	if !allowNamespace(obj.GetNamespace()) {
+		if obj.GetNamespace() == metav1.NamespaceAll {
+			return nil, fmt.Errorf("cluster-scoped %s is not allowed on this agent", gvk.Kind)
+		}
+		return nil, fmt.Errorf("namespace %s is not allowed on this agent", obj.GetNamespace())
+	}
+	return resource, nil
+}
+
+// Resolves the resource of the object's kind and settles its namespace,
+// cluster-scoped objects are stripped of theirs.
+func resourceFor(
+	dyn dynamic.Interface,
+	mapper *restmapper.DeferredDiscoveryRESTMapper,
+	namespace string,
+	obj *unstructured.Unstructured,
+) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// the kind may come from a CRD installed after discovery was cached
+		mapper.Reset()
+		mapping, err = mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to map %s: %w", gvk, err)
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		obj.SetNamespace(metav1.NamespaceAll)
+		return dyn.Resource(mapping.Resource), nil
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(namespace)
+	}
+	return dyn.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+}
+
+// namespace/name, or only the name for cluster-scoped objects.
+func ObjectKey(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
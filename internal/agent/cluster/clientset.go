@@ -4,25 +4,70 @@ import (
 	"flag"
 	"fmt"
 	"path/filepath"
+	"sync"
 
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 )
 
-func GetClientSet() (*kubernetes.Clientset, error) {
-	var kubeconfig *string
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+var (
+	restConfigOnce sync.Once
+	restConfig     *rest.Config
+	restConfigErr  error
+)
+
+// Rest config from the kubeconfig flag, the flag can only be registered once.
+func getRestConfig() (*rest.Config, error) {
+	restConfigOnce.Do(func() {
+		var kubeconfig *string
+		if home := homedir.HomeDir(); home != "" {
+			kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
+		} else {
+			kubeconfig = flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
+		}
+		flag.Parse()
+		// Use the kubeconfig flag to specify the path to the kubeconfig file
+		restConfig, restConfigErr = clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	})
+	if restConfigErr != nil {
+		return nil, fmt.Errorf("failed to build config: %w", restConfigErr)
 	}
-	flag.Parse()
-	// Use the kubeconfig flag to specify the path to the kubeconfig file
-	cfg, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	return restConfig, nil
+}
+
+func GetClientSet() (*kubernetes.Clientset, error) {
+	cfg, err := getRestConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to build config: %w", err)
+		return nil, err
 	}
 	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
 	return clientset, nil
 }
+
+// Dynamic client and REST mapper used to apply objects of any kind.
+// The mapper caches discovery, it is reset when a kind is not found so
+// CRDs installed later are picked up.
+func GetDynamicClient() (dynamic.Interface, *restmapper.DeferredDiscoveryRESTMapper, error) {
+	cfg, err := getRestConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	dyn, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+	return dyn, mapper, nil
+}
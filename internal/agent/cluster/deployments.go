@@ -2,19 +2,25 @@ package cluster
 
 import (
 	"context"
-	"os"
+	"fmt"
 
 	"k8s.io/client-go/kubernetes"
 	"github.com/gogo/protobuf/proto"
 
-	v1 "k8s.io/client-go/applyconfigurations/apps/v1"
-	yaml "sigs.k8s.io/yaml"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	log "github.com/sirupsen/logrus"
 
 	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
 )
 
+const (
+	// Field manager used for every object applied by the agent
+	FIELD_MANAGER = "go-k8s-cord-agent"
+	// Field manager of the imperative operations(scale, restart, pause,
+	// rollback), kept apart so they do not make an object look applied by cord
+	OPERATIONS_FIELD_MANAGER = "go-k8s-cord-operations"
+)
+
 func GetDeployments(
 	ctx context.Context,
 	client *kubernetes.Clientset,
@@ -29,14 +35,18 @@ func GetDeployments(
 
 	for _, item := range deployements.Items {
 		metadata := &pba.DeploymentMetadata{
-			ApiVersion:        proto.String(item.APIVersion),
-			Uid:               proto.String(string(item.UID)),
-			Name:              proto.String(item.Name),
-			Replicas:          item.Spec.Replicas,
-			ReadyReplicas:     proto.Int32(item.Status.ReadyReplicas),
-			AvailableReplicas: proto.Int32(item.Status.AvailableReplicas),
-			UpdatedReplicas:   proto.Int32(item.Status.UpdatedReplicas),
-			CreationTimestamp: proto.Int64(item.CreationTimestamp.Unix()),
+			ApiVersion:         proto.String(item.APIVersion),
+			Uid:                proto.String(string(item.UID)),
+			Name:               proto.String(item.Name),
+			Namespace:          proto.String(item.Namespace),
+			Replicas:           item.Spec.Replicas,
+			ReadyReplicas:      proto.Int32(item.Status.ReadyReplicas),
+			AvailableReplicas:  proto.Int32(item.Status.AvailableReplicas),
+			UpdatedReplicas:    proto.Int32(item.Status.UpdatedReplicas),
+			CreationTimestamp:  proto.Int64(item.CreationTimestamp.Unix()),
+			Generation:         proto.Int64(item.Generation),
+			ObservedGeneration: proto.Int64(item.Status.ObservedGeneration),
+			Paused:             proto.Bool(item.Spec.Paused),
 		}
 		metadataList = append(metadataList, metadata)
 	}
@@ -44,53 +54,71 @@ func GetDeployments(
 	return metadataList, nil
 }
 
-func ApplyDeployments(
+// Deletes the deployments by name, returning the result for each of them.
+func RemoveDeployments(
 	ctx context.Context,
 	client *kubernetes.Clientset,
 	namespace string,
 	deployments []string,
-) error {
+) []*pba.ObjectResult {
+	results := []*pba.ObjectResult{}
 	for _, deployment := range deployments {
-		yamlHandle, err := os.ReadFile(deployment)
-		if err != nil {
-			log.Errorf("Failed to read deployment file %s: %v", deployment, err)
-			continue
-		}
-		dep := &v1.DeploymentApplyConfiguration{}
-		if err := yaml.Unmarshal(yamlHandle, dep); err != nil {
-			log.Errorf("Failed to unmarshal deployment file %s: %v", deployment, err)
-			continue
-		}
-		if *dep.Name == "" {
-			log.Errorf("Deployment file %s does not contain a valid deployment name", deployment)
-			continue
+		result := &pba.ObjectResult{
+			ApiVersion: proto.String("apps/v1"),
+			Kind:       proto.String("Deployment"),
+			Namespace:  proto.String(namespace),
+			Name:       proto.String(deployment),
+			Status:     pba.ObjectStatus_REMOVED.Enum(),
 		}
-
-		_, err = client.AppsV1().Deployments(namespace).Apply(ctx, dep, metav1.ApplyOptions{
-			FieldManager: "go-k8s-cord-agent",
-			Force:        true,
-		})
-		if err != nil {
-			log.Errorf("Failed to apply deployment %s: %v", *dep.Name, err)
-			continue
+		if err := client.AppsV1().Deployments(namespace).Delete(ctx, deployment, metav1.DeleteOptions{}); err != nil {
+			log.Errorf("Failed to remove deployment %s: %v", deployment, err)
+			result.Status = pba.ObjectStatus_FAILED.Enum()
+			result.Error = proto.String(err.Error())
+		} else {
+			log.Infof("Removed deployment %s from namespace %s", deployment, namespace)
 		}
+		results = append(results, result)
 	}
-	log.Infof("Applied %d deployments in namespace %s", len(deployments), namespace)
-	return nil
+	return results
 }
 
-func RemoveDeployments(
+// Removes every deployment in the namespace that was applied by the agent,
+// metav1.NamespaceAll drains the whole cluster.
+// Returns the removed deployments as namespace/name.
+func DrainManagedDeployments(
 	ctx context.Context,
 	client *kubernetes.Clientset,
 	namespace string,
-	deployments []string,
-) error {
-	for _, deployment := range deployments {
-		if err := client.AppsV1().Deployments(namespace).Delete(ctx, deployment, metav1.DeleteOptions{}); err != nil {
-			log.Errorf("Failed to remove deployment %s: %v", deployment, err)
+) ([]string, error) {
+	deployments, err := client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	drained := []string{}
+	for _, item := range deployments.Items {
+		if !appliedByAgent(item.ObjectMeta) {
 			continue
 		}
-		log.Infof("Removed deployment %s from namespace %s", deployment, namespace)
+		if err := client.AppsV1().Deployments(item.Namespace).Delete(ctx, item.Name, metav1.DeleteOptions{}); err != nil {
+			return drained, fmt.Errorf("failed to drain deployment %s/%s: %w", item.Namespace, item.Name, err)
+		}
+		log.Infof("Drained deployment %s from namespace %s", item.Name, item.Namespace)
+		drained = append(drained, item.Namespace+"/"+item.Name)
+	}
+	return drained, nil
+}
+
+// Objects applied by the agent carry the managed-by label, older ones at
+// least an apply of the agent's field manager.
+func appliedByAgent(meta metav1.ObjectMeta) bool {
+	if meta.Labels[MANAGED_BY_LABEL] == MANAGED_BY {
+		return true
+	}
+	for _, field := range meta.ManagedFields {
+		if field.Manager == FIELD_MANAGER && field.Operation == metav1.ManagedFieldsOperationApply {
+			return true
+		}
 	}
-	return nil
+	return false
 }
@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAppliedByAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		meta metav1.ObjectMeta
+		want bool
+	}{
+		{
+			name: "managed-by label",
+			meta: metav1.ObjectMeta{Labels: map[string]string{MANAGED_BY_LABEL: MANAGED_BY}},
+			want: true,
+		},
+		{
+			name: "apply of the agent",
+			meta: metav1.ObjectMeta{ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: FIELD_MANAGER, Operation: metav1.ManagedFieldsOperationApply},
+			}},
+			want: true,
+		},
+		{
+			// e.g. kube-system/coredns after a restart from central
+			name: "only operations",
+			meta: metav1.ObjectMeta{ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate},
+				{Manager: OPERATIONS_FIELD_MANAGER, Operation: metav1.ManagedFieldsOperationUpdate},
+			}},
+			want: false,
+		},
+		{
+			name: "update by the agent's manager",
+			meta: metav1.ObjectMeta{ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: FIELD_MANAGER, Operation: metav1.ManagedFieldsOperationUpdate},
+			}},
+			want: false,
+		},
+		{
+			name: "other managed-by",
+			meta: metav1.ObjectMeta{Labels: map[string]string{MANAGED_BY_LABEL: "helm"}},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appliedByAgent(tt.meta); got != tt.want {
+				t.Fatalf("appliedByAgent() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
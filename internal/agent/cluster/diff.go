@@ -0,0 +1,223 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	yaml "sigs.k8s.io/yaml"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+// Lines of context around each change in the unified diffs.
+const DIFF_CONTEXT = 3
+
+// Dry-runs the manifests and diffs them against the live objects.
+// Objects that would change are reported as APPLIED along with their diff.
+func DiffManifests(
+	ctx context.Context,
+	dyn dynamic.Interface,
+	mapper *restmapper.DeferredDiscoveryRESTMapper,
+	namespace string,
+	allowNamespace func(string) bool,
+	manifests []Manifest,
+) []*pba.ObjectResult {
+	results := []*pba.ObjectResult{}
+	for _, m := range manifests {
+		if m.Err != nil {
+			results = append(results, &pba.ObjectResult{
+				File:   proto.String(m.File),
+				Status: pba.ObjectStatus_FAILED.Enum(),
+				Error:  proto.String(m.Err.Error()),
+			})
+			continue
+		}
+
+		obj := m.Object
+		result := &pba.ObjectResult{
+			File:       proto.String(m.File),
+			ApiVersion: proto.String(obj.GetAPIVersion()),
+			Kind:       proto.String(obj.GetKind()),
+			Name:       proto.String(obj.GetName()),
+		}
+		diff, err := DiffObject(ctx, dyn, mapper, namespace, allowNamespace, obj)
+		result.Namespace = proto.String(obj.GetNamespace())
+		switch {
+		case err != nil:
+			log.Errorf("Failed to diff %s %s from %s: %v", obj.GetKind(), obj.GetName(), m.File, err)
+			result.Status = pba.ObjectStatus_FAILED.Enum()
+			result.Error = proto.String(err.Error())
+		case diff != "":
+			result.Status = pba.ObjectStatus_APPLIED.Enum()
+			result.Diff = proto.String(diff)
+		default:
+			result.Status = pba.ObjectStatus_UNCHANGED.Enum()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Server-side dry-run apply with the agent's field manager, returns the
+// unified diff from the live object to the result, empty when nothing
+// would change.
+func DiffObject(
+	ctx context.Context,
+	dyn dynamic.Interface,
+	mapper *restmapper.DeferredDiscoveryRESTMapper,
+	namespace string,
+	allowNamespace func(string) bool,
+	obj *unstructured.Unstructured,
+) (string, error) {
+	resource, err := prepareObject(dyn, mapper, namespace, allowNamespace, obj)
+	if err != nil {
+		return "", err
+	}
+
+	live := ""
+	current, err := resource.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err == nil {
+		if live, err = diffYAML(current); err != nil {
+			return "", err
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	dryRun, err := resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{
+		FieldManager: FIELD_MANAGER,
+		Force:        true,
+		DryRun:       []string{metav1.DryRunAll},
+	})
+	if err != nil {
+		return "", err
+	}
+	applied, err := diffYAML(dryRun)
+	if err != nil {
+		return "", err
+	}
+
+	key := obj.GetKind() + "/" + ObjectKey(obj)
+	return UnifiedDiff("live/"+key, "applied/"+key, live, applied), nil
+}
+
+// YAML of the object without the fields every write changes.
+func diffYAML(obj *unstructured.Unstructured) (string, error) {
+	obj = obj.DeepCopy()
+	obj.SetManagedFields(nil)
+	obj.SetResourceVersion("")
+	obj.SetGeneration(0)
+	out, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s: %w", obj.GetName(), err)
+	}
+	return string(out), nil
+}
+
+type diffLine struct {
+	op   diffmatchpatch.Operation
+	text string
+}
+
+// Line based unified diff of a and b, empty when they are equal.
+func UnifiedDiff(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	dmp := diffmatchpatch.New()
+	ca, cb, lines := dmp.DiffLinesToChars(a, b)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(ca, cb, false), lines)
+
+	all := []diffLine{}
+	for _, d := range diffs {
+		for _, line := range strings.SplitAfter(d.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			all = append(all, diffLine{op: d.Type, text: strings.TrimSuffix(line, "\n")})
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	// line numbers in a and b at the current position
+	aLine, bLine := 1, 1
+	for i := 0; i < len(all); {
+		if all[i].op == diffmatchpatch.DiffEqual {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+
+		// a hunk starts DIFF_CONTEXT lines before the change and ends once
+		// two changes are more than 2*DIFF_CONTEXT equal lines apart
+		start := max(i-DIFF_CONTEXT, 0)
+		end := i
+		for equal := 0; end < len(all) && equal <= 2*DIFF_CONTEXT; end++ {
+			if all[end].op == diffmatchpatch.DiffEqual {
+				equal++
+			} else {
+				equal = 0
+			}
+		}
+		// trims the trailing context to DIFF_CONTEXT lines
+		for end > i && all[end-1].op == diffmatchpatch.DiffEqual && trailingEqual(all[:end]) > DIFF_CONTEXT {
+			end--
+		}
+
+		hunkA, hunkB := aLine-(i-start), bLine-(i-start)
+		var body strings.Builder
+		countA, countB := 0, 0
+		for _, l := range all[start:end] {
+			switch l.op {
+			case diffmatchpatch.DiffEqual:
+				body.WriteString(" " + l.text + "\n")
+				countA++
+				countB++
+			case diffmatchpatch.DiffDelete:
+				body.WriteString("-" + l.text + "\n")
+				countA++
+			case diffmatchpatch.DiffInsert:
+				body.WriteString("+" + l.text + "\n")
+				countB++
+			}
+		}
+		if countA == 0 {
+			hunkA--
+		}
+		if countB == 0 {
+			hunkB--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunkA, countA, hunkB, countB)
+		out.WriteString(body.String())
+
+		for _, l := range all[i:end] {
+			if l.op != diffmatchpatch.DiffInsert {
+				aLine++
+			}
+			if l.op != diffmatchpatch.DiffDelete {
+				bLine++
+			}
+		}
+		i = end
+	}
+	return out.String()
+}
+
+func trailingEqual(lines []diffLine) int {
+	n := 0
+	for i := len(lines) - 1; i >= 0 && lines[i].op == diffmatchpatch.DiffEqual; i-- {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,65 @@
+package cluster
+
+const (
+	// Recommended label for the tool managing an object
+	MANAGED_BY_LABEL = "app.kubernetes.io/managed-by"
+	MANAGED_BY       = "go-k8s-cord"
+	// Deployments repository commit the object was applied from
+	COMMIT_LABEL = "cord/commit"
+	// File the object was applied from, relative to the deployments directory
+	SOURCE_FILE_ANNOTATION = "cord/source-file"
+	// Set on objects applied by the reconcile loop, which owns and prunes them
+	RECONCILED_LABEL = "cord/reconciled"
+)
+
+// Selects the objects owned by the reconcile loop.
+func ReconciledSelector() string {
+	return MANAGED_BY_LABEL + "=" + MANAGED_BY + "," + RECONCILED_LABEL + "=true"
+}
+
+// Selects the objects cord applied at the commit.
+func CommitSelector(commit string) string {
+	return MANAGED_BY_LABEL + "=" + MANAGED_BY + "," + COMMIT_LABEL + "=" + commit
+}
+
+// Marks the objects as owned by the reconcile loop, see ReconciledSelector.
+func MarkReconciled(manifests []Manifest) {
+	for _, m := range manifests {
+		if m.Object == nil {
+			continue
+		}
+		labels := m.Object.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[RECONCILED_LABEL] = "true"
+		m.Object.SetLabels(labels)
+	}
+}
+
+// Marks every object with the file it came from and the commit it was
+// applied at, an empty commit leaves the commit label out.
+func LabelManifests(manifests []Manifest, commit string) {
+	for _, m := range manifests {
+		if m.Object == nil {
+			continue
+		}
+		labels := m.Object.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[MANAGED_BY_LABEL] = MANAGED_BY
+		if commit != "" {
+			labels[COMMIT_LABEL] = commit
+		}
+		m.Object.SetLabels(labels)
+
+		annotations := m.Object.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[SOURCE_FILE_ANNOTATION] = m.File
+		m.Object.SetAnnotations(annotations)
+	}
+}
+
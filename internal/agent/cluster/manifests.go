@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// An object read from the deployments directory, along with the file it
+// came from(relative to the directory). Object is nil and Err is set when
+// the file could not be read.
+type Manifest struct {
+	File   string
+	Object *unstructured.Unstructured
+	Err    error
+}
+
+// Reads every object from the given files and directories under root.
+// Files may hold several YAML documents, JSON objects or List objects,
+// directories are walked recursively in lexical order. Namespaces and CRDs
+// are moved first so the objects depending on them can be applied.
+func LoadManifests(root string, paths []string) []Manifest {
+	manifests := []Manifest{}
+	for _, path := range paths {
+		files, err := manifestFiles(root, path)
+		if err != nil {
+			manifests = append(manifests, Manifest{File: path, Err: err})
+			continue
+		}
+		for _, file := range files {
+			objects, err := readManifestFile(filepath.Join(root, file))
+			if err != nil {
+				manifests = append(manifests, Manifest{File: file, Err: err})
+				continue
+			}
+			for _, obj := range objects {
+				manifests = append(manifests, Manifest{File: file, Object: obj})
+			}
+		}
+	}
+
+	slices.SortStableFunc(manifests, func(a, b Manifest) int {
+		return applyOrder(a) - applyOrder(b)
+	})
+	return manifests
+}
+
+// Same as LoadManifests, except that a path missing from root holds no
+// objects instead of failing: a path deleted from the repository leaves an
+// empty desired set, so the reconcile loop can still prune its objects.
+func LoadDesiredManifests(root string, paths []string) []Manifest {
+	existing := []string{}
+	for _, path := range paths {
+		if _, err := os.Stat(filepath.Join(root, path)); errors.Is(err, fs.ErrNotExist) {
+			log.Infof("%s is not in the deployments directory, nothing to apply from it", path)
+			continue
+		}
+		existing = append(existing, path)
+	}
+	return LoadManifests(root, existing)
+}
+
+// Files of the path relative to root, the path itself when it is a file.
+func manifestFiles(root string, path string) ([]string, error) {
+	full := filepath.Join(root, path)
+	info, err := os.Stat(full)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files := []string{}
+	err = filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// skips the repository metadata and other hidden directories
+			if p != full && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(p) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	return files, err
+}
+
+func readManifestFile(file string) ([]*unstructured.Unstructured, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []*unstructured.Unstructured{}
+	decoder := k8syaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4096)
+	for {
+		doc := map[string]any{}
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		// empty documents, e.g. a leading "---"
+		if len(doc) == 0 {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{Object: doc}
+		if !obj.IsList() {
+			objects = append(objects, obj)
+			continue
+		}
+		err := obj.EachListItem(func(item runtime.Object) error {
+			objects = append(objects, item.(*unstructured.Unstructured))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+func applyOrder(m Manifest) int {
+	if m.Object == nil {
+		return 2
+	}
+	switch m.Object.GetKind() {
+	case "Namespace":
+		return 0
+	case "CustomResourceDefinition":
+		return 1
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfigMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: kept
+data:
+  key: value
+`
+
+func writeManifest(t *testing.T, root string, file string, content string) {
+	t.Helper()
+	path := filepath.Join(root, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A file or directory deleted from the repository while still assigned must
+// not fail the reconcile, otherwise its objects would never be pruned.
+func TestLoadDesiredManifestsSkipsDeletedPaths(t *testing.T) {
+	root := t.TempDir()
+	writeManifest(t, root, "apps/kept.yaml", testConfigMap)
+
+	manifests := LoadDesiredManifests(root, []string{"apps/kept.yaml", "apps/deleted.yaml", "deleted-dir"})
+	if len(manifests) != 1 {
+		t.Fatalf("got %d manifests, want 1: %+v", len(manifests), manifests)
+	}
+	if manifests[0].Err != nil {
+		t.Fatalf("unexpected error: %v", manifests[0].Err)
+	}
+	if name := manifests[0].Object.GetName(); name != "kept" {
+		t.Fatalf("got object %q, want kept", name)
+	}
+
+	if manifests := LoadDesiredManifests(root, []string{"apps/deleted.yaml"}); len(manifests) != 0 {
+		t.Fatalf("a deleted path should hold no objects, got %+v", manifests)
+	}
+}
+
+// Unreadable files still fail, their objects are not known.
+func TestLoadDesiredManifestsKeepsReadErrors(t *testing.T) {
+	root := t.TempDir()
+	writeManifest(t, root, "broken.yaml", "kind: [")
+
+	manifests := LoadDesiredManifests(root, []string{"broken.yaml"})
+	if len(manifests) != 1 || manifests[0].Err == nil {
+		t.Fatalf("want a single failed manifest, got %+v", manifests)
+	}
+}
+
+// Explicit applies keep failing on files that do not exist.
+func TestLoadManifestsFailsOnMissingPath(t *testing.T) {
+	root := t.TempDir()
+
+	manifests := LoadManifests(root, []string{"deleted.yaml"})
+	if len(manifests) != 1 || manifests[0].Err == nil {
+		t.Fatalf("want a single failed manifest, got %+v", manifests)
+	}
+}
@@ -0,0 +1,126 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Pod template annotation kubectl rollout restart sets, changing it rolls
+// every pod.
+const RESTARTED_AT_ANNOTATION = "kubectl.kubernetes.io/restartedAt"
+
+// Sets the desired replicas through the scale subresource, returning the
+// previous value.
+func ScaleDeployment(
+	ctx context.Context,
+	client *kubernetes.Clientset,
+	namespace string,
+	name string,
+	replicas int32,
+) (int32, error) {
+	scale, err := client.AppsV1().Deployments(namespace).GetScale(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	previous := scale.Spec.Replicas
+	scale.Spec.Replicas = replicas
+	_, err = client.AppsV1().Deployments(namespace).UpdateScale(ctx, name, scale, metav1.UpdateOptions{
+		FieldManager: OPERATIONS_FIELD_MANAGER,
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Infof("Scaled deployment %s/%s from %d to %d replicas", namespace, name, previous, replicas)
+	return previous, nil
+}
+
+// Stamps the pod template with the current time so the deployment rolls
+// every pod, returns the stamp.
+func RestartDeployment(
+	ctx context.Context,
+	client *kubernetes.Clientset,
+	namespace string,
+	name string,
+) (string, error) {
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if deployment.Spec.Paused {
+		return "", fmt.Errorf("%w, resume it before restarting", ErrDeploymentPaused)
+	}
+
+	restartedAt := time.Now().Format(time.RFC3339)
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"annotations": map[string]string{
+						RESTARTED_AT_ANNOTATION: restartedAt,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := patchDeployment(ctx, client, namespace, name, patch); err != nil {
+		return "", err
+	}
+	log.Infof("Restarted deployment %s/%s", namespace, name)
+	return restartedAt, nil
+}
+
+// Pauses or resumes the deployment, returns false when it already was in
+// that state.
+func SetDeploymentPaused(
+	ctx context.Context,
+	client *kubernetes.Clientset,
+	namespace string,
+	name string,
+	paused bool,
+) (bool, error) {
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	if deployment.Spec.Paused == paused {
+		return false, nil
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"paused": paused,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := patchDeployment(ctx, client, namespace, name, patch); err != nil {
+		return false, err
+	}
+	log.Infof("Set paused=%t on deployment %s/%s", paused, namespace, name)
+	return true, nil
+}
+
+func patchDeployment(
+	ctx context.Context,
+	client *kubernetes.Clientset,
+	namespace string,
+	name string,
+	patch []byte,
+) error {
+	_, err := client.AppsV1().Deployments(namespace).Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{
+		FieldManager: OPERATIONS_FIELD_MANAGER,
+	})
+	return err
+}
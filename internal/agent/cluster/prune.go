@@ -0,0 +1,165 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+const (
+	// Objects annotated with "true" are never pruned
+	PROTECT_ANNOTATION = "cord/protect"
+)
+
+// Objects the agent applied that carry the label selector, over every kind
+// that can be listed and deleted. Namespaced kinds are only listed in the
+// given namespaces, cluster-scoped kinds only when metav1.NamespaceAll is one
+// of them. Kinds the agent is forbidden to list are skipped.
+func ListAppliedObjects(
+	ctx context.Context,
+	disc discovery.DiscoveryInterface,
+	dyn dynamic.Interface,
+	selector string,
+	namespaces []string,
+) ([]unstructured.Unstructured, error) {
+	lists, err := discovery.ServerPreferredResources(disc)
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("failed to discover resources: %w", err)
+		}
+		// an unavailable aggregated api should not stop the others
+		log.Warn("Some resources could not be discovered: ", err)
+	}
+	lists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "delete"}}, lists)
+
+	allNamespaces := false
+	for _, namespace := range namespaces {
+		if namespace == metav1.NamespaceAll {
+			allNamespaces = true
+		}
+	}
+	if allNamespaces {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	objects := []unstructured.Unstructured{}
+	seen := map[types.UID]bool{}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, res := range list.APIResources {
+			// subresources, e.g. deployments/scale
+			if strings.Contains(res.Name, "/") {
+				continue
+			}
+			resource := dyn.Resource(gv.WithResource(res.Name))
+			targets := []dynamic.ResourceInterface{}
+			if !res.Namespaced {
+				if allNamespaces {
+					targets = append(targets, resource)
+				}
+			} else {
+				for _, namespace := range namespaces {
+					targets = append(targets, resource.Namespace(namespace))
+				}
+			}
+
+			for _, target := range targets {
+				items, err := target.List(ctx, metav1.ListOptions{LabelSelector: selector})
+				if apierrors.IsForbidden(err) {
+					log.Warnf("Not allowed to list %s, skipping them: %v", res.Name, err)
+					continue
+				}
+				if err != nil {
+					return nil, fmt.Errorf("failed to list %s: %w", res.Name, err)
+				}
+				for _, item := range items.Items {
+					if !appliedObject(&item) {
+						continue
+					}
+					// the same objects are served by several groups, e.g. events
+					if seen[item.GetUID()] {
+						continue
+					}
+					seen[item.GetUID()] = true
+					objects = append(objects, item)
+				}
+			}
+		}
+	}
+	return objects, nil
+}
+
+// Objects copying the labels of an applied one, e.g. the Endpoints and
+// EndpointSlices of a Service, have no source file or are owned by a
+// controller.
+func appliedObject(obj *unstructured.Unstructured) bool {
+	if _, ok := obj.GetAnnotations()[SOURCE_FILE_ANNOTATION]; !ok {
+		return false
+	}
+	return metav1.GetControllerOf(obj) == nil
+}
+
+// Deletes the objects with the given propagation policy, objects annotated
+// with PROTECT_ANNOTATION are left alone and reported unchanged.
+// Objects that are already gone count as removed.
+func PruneObjects(
+	ctx context.Context,
+	dyn dynamic.Interface,
+	mapper *restmapper.DeferredDiscoveryRESTMapper,
+	objects []unstructured.Unstructured,
+	policy metav1.DeletionPropagation,
+) []*pba.ObjectResult {
+	results := []*pba.ObjectResult{}
+	for i := range objects {
+		obj := &objects[i]
+		result := &pba.ObjectResult{
+			File:       proto.String(obj.GetAnnotations()[SOURCE_FILE_ANNOTATION]),
+			ApiVersion: proto.String(obj.GetAPIVersion()),
+			Kind:       proto.String(obj.GetKind()),
+			Namespace:  proto.String(obj.GetNamespace()),
+			Name:       proto.String(obj.GetName()),
+			Status:     pba.ObjectStatus_REMOVED.Enum(),
+		}
+		if obj.GetAnnotations()[PROTECT_ANNOTATION] == "true" {
+			log.Infof("Not pruning protected %s %s", obj.GetKind(), ObjectKey(obj))
+			result.Status = pba.ObjectStatus_UNCHANGED.Enum()
+			results = append(results, result)
+			continue
+		}
+
+		resource, err := resourceFor(dyn, mapper, obj.GetNamespace(), obj)
+		if err == nil {
+			err = resource.Delete(ctx, obj.GetName(), metav1.DeleteOptions{
+				PropagationPolicy: &policy,
+			})
+		}
+		switch {
+		case apierrors.IsNotFound(err):
+			log.Debugf("%s %s is already gone", obj.GetKind(), ObjectKey(obj))
+		case err != nil:
+			log.Errorf("Failed to prune %s %s: %v", obj.GetKind(), ObjectKey(obj), err)
+			result.Status = pba.ObjectStatus_FAILED.Enum()
+			result.Error = proto.String(err.Error())
+		default:
+			log.Infof("Pruned %s %s", obj.GetKind(), ObjectKey(obj))
+		}
+		results = append(results, result)
+	}
+	return results
+}
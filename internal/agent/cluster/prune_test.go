@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestAppliedObject(t *testing.T) {
+	controller := true
+	labels := map[string]string{MANAGED_BY_LABEL: MANAGED_BY, RECONCILED_LABEL: "true"}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		owners      []metav1.OwnerReference
+		want        bool
+	}{
+		{
+			name:        "applied from a file",
+			annotations: map[string]string{SOURCE_FILE_ANNOTATION: "apps/web.yaml"},
+			want:        true,
+		},
+		{
+			// Endpoints copy the labels of their Service
+			name: "labels copied by a controller",
+			want: false,
+		},
+		{
+			// EndpointSlices carry the labels and an owner reference
+			name:        "controlled",
+			annotations: map[string]string{SOURCE_FILE_ANNOTATION: "apps/web.yaml"},
+			owners: []metav1.OwnerReference{
+				{APIVersion: "v1", Kind: "Service", Name: "web", UID: "1", Controller: &controller},
+			},
+			want: false,
+		},
+		{
+			name:        "owned without controller",
+			annotations: map[string]string{SOURCE_FILE_ANNOTATION: "apps/web.yaml"},
+			owners: []metav1.OwnerReference{
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "parent", UID: "2"},
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{}
+			obj.SetLabels(labels)
+			obj.SetAnnotations(tt.annotations)
+			obj.SetOwnerReferences(tt.owners)
+			if got := appliedObject(obj); got != tt.want {
+				t.Fatalf("appliedObject() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
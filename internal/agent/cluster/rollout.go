@@ -0,0 +1,243 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+const (
+	// set by the deployment controller on deployments and their replica sets
+	REVISION_ANNOTATION     = "deployment.kubernetes.io/revision"
+	CHANGE_CAUSE_ANNOTATION = "kubernetes.io/change-cause"
+	// reason of the Progressing condition once progressDeadlineSeconds passed
+	PROGRESS_DEADLINE_EXCEEDED = "ProgressDeadlineExceeded"
+)
+
+var (
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrDeploymentPaused = errors.New("deployment is paused")
+)
+
+func GetRolloutStatus(
+	ctx context.Context,
+	client *kubernetes.Clientset,
+	namespace string,
+	name string,
+) (*pba.DeploymentRolloutStatus, error) {
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	conditions := []*pba.DeploymentCondition{}
+	for _, c := range deployment.Status.Conditions {
+		conditions = append(conditions, &pba.DeploymentCondition{
+			Type:               proto.String(string(c.Type)),
+			Status:             proto.String(string(c.Status)),
+			Reason:             proto.String(c.Reason),
+			Message:            proto.String(c.Message),
+			LastUpdateTime:     proto.Int64(c.LastUpdateTime.Unix()),
+			LastTransitionTime: proto.Int64(c.LastTransitionTime.Unix()),
+		})
+	}
+	complete, stuck, message := rolloutProgress(deployment, replicas)
+
+	return &pba.DeploymentRolloutStatus{
+		Name:                proto.String(deployment.Name),
+		Namespace:           proto.String(deployment.Namespace),
+		Revision:            proto.Int64(revision(deployment)),
+		Generation:          proto.Int64(deployment.Generation),
+		ObservedGeneration:  proto.Int64(deployment.Status.ObservedGeneration),
+		Replicas:            proto.Int32(replicas),
+		UpdatedReplicas:     proto.Int32(deployment.Status.UpdatedReplicas),
+		ReadyReplicas:       proto.Int32(deployment.Status.ReadyReplicas),
+		AvailableReplicas:   proto.Int32(deployment.Status.AvailableReplicas),
+		UnavailableReplicas: proto.Int32(deployment.Status.UnavailableReplicas),
+		Complete:            proto.Bool(complete),
+		Stuck:               proto.Bool(stuck),
+		Paused:              proto.Bool(deployment.Spec.Paused),
+		Message:             proto.String(message),
+		Conditions:          conditions,
+	}, nil
+}
+
+// Same checks, in the same order, as kubectl rollout status.
+func rolloutProgress(deployment *appsv1.Deployment, replicas int32) (complete bool, stuck bool, message string) {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false, false, "waiting for the deployment spec update to be observed"
+	}
+	for _, c := range deployment.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == PROGRESS_DEADLINE_EXCEEDED {
+			return false, true, fmt.Sprintf("deployment %q exceeded its progress deadline", deployment.Name)
+		}
+	}
+	status := deployment.Status
+	if status.UpdatedReplicas < replicas {
+		return false, false, fmt.Sprintf("%d out of %d new replicas have been updated", status.UpdatedReplicas, replicas)
+	}
+	if status.Replicas > status.UpdatedReplicas {
+		return false, false, fmt.Sprintf("%d old replicas are pending termination", status.Replicas-status.UpdatedReplicas)
+	}
+	if status.AvailableReplicas < status.UpdatedReplicas {
+		return false, false, fmt.Sprintf("%d of %d updated replicas are available", status.AvailableReplicas, status.UpdatedReplicas)
+	}
+	return true, false, "successfully rolled out"
+}
+
+// Revision annotation of a deployment or replica set, 0 when missing.
+func revision(obj metav1.Object) int64 {
+	value, err := strconv.ParseInt(obj.GetAnnotations()[REVISION_ANNOTATION], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// Replica sets controlled by the deployment, oldest revision first.
+func ownedReplicaSets(
+	ctx context.Context,
+	client *kubernetes.Clientset,
+	deployment *appsv1.Deployment,
+) ([]appsv1.ReplicaSet, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	sets, err := client.AppsV1().ReplicaSets(deployment.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	owned := []appsv1.ReplicaSet{}
+	for _, set := range sets.Items {
+		if metav1.IsControlledBy(&set, deployment) {
+			owned = append(owned, set)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return revision(&owned[i]) < revision(&owned[j])
+	})
+	return owned, nil
+}
+
+func GetDeploymentHistory(
+	ctx context.Context,
+	client *kubernetes.Clientset,
+	namespace string,
+	name string,
+) ([]*pba.DeploymentRevision, error) {
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	sets, err := ownedReplicaSets(ctx, client, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	current := revision(deployment)
+	revisions := []*pba.DeploymentRevision{}
+	for _, set := range sets {
+		images := []string{}
+		for _, c := range set.Spec.Template.Spec.Containers {
+			images = append(images, c.Image)
+		}
+		revisions = append(revisions, &pba.DeploymentRevision{
+			Revision:          proto.Int64(revision(&set)),
+			ReplicaSet:        proto.String(set.Name),
+			Images:            images,
+			ChangeCause:       proto.String(set.Annotations[CHANGE_CAUSE_ANNOTATION]),
+			Replicas:          proto.Int32(set.Status.Replicas),
+			CreationTimestamp: proto.Int64(set.CreationTimestamp.Unix()),
+			Current:           proto.Bool(revision(&set) == current),
+		})
+	}
+	return revisions, nil
+}
+
+// Puts the pod template of a revision back on the deployment, the revision
+// before the current one when toRevision is 0. The deployment controller
+// then rolls it out as a new revision.
+// Returns the revision rolled back to and whether the template changed.
+func RollbackDeployment(
+	ctx context.Context,
+	client *kubernetes.Clientset,
+	namespace string,
+	name string,
+	toRevision int64,
+) (int64, bool, error) {
+	deployment, err := client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, false, err
+	}
+	if deployment.Spec.Paused {
+		return 0, false, fmt.Errorf("%w, resume it before rolling back", ErrDeploymentPaused)
+	}
+	sets, err := ownedReplicaSets(ctx, client, deployment)
+	if err != nil {
+		return 0, false, err
+	}
+
+	current := revision(deployment)
+	var target *appsv1.ReplicaSet
+	for i := range sets {
+		found := revision(&sets[i])
+		// sorted by revision, the last one below current is the previous one
+		if (toRevision == 0 && found < current) || (toRevision != 0 && found == toRevision) {
+			target = &sets[i]
+		}
+	}
+	if target == nil {
+		if toRevision == 0 {
+			return 0, false, fmt.Errorf("%w: %s has no revision before %d", ErrRevisionNotFound, name, current)
+		}
+		return 0, false, fmt.Errorf("%w: %s has no revision %d", ErrRevisionNotFound, name, toRevision)
+	}
+	targetRevision := revision(target)
+
+	// the hash label is added by the controller to each replica set
+	template := target.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	if equality.Semantic.DeepEqual(*template, deployment.Spec.Template) {
+		log.Infof("Deployment %s/%s already runs revision %d", namespace, name, targetRevision)
+		return targetRevision, false, nil
+	}
+
+	// like kubectl, the test fails the patch if the deployment changed since
+	// it was read instead of overwriting the change
+	patch, err := json.Marshal([]map[string]any{
+		{"op": "test", "path": "/metadata/resourceVersion", "value": deployment.ResourceVersion},
+		{"op": "replace", "path": "/spec/template", "value": template},
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	_, err = client.AppsV1().Deployments(namespace).Patch(ctx, name, types.JSONPatchType, patch, metav1.PatchOptions{
+		FieldManager: OPERATIONS_FIELD_MANAGER,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	log.Infof("Rolled deployment %s/%s back to revision %d", namespace, name, targetRevision)
+	return targetRevision, true, nil
+}
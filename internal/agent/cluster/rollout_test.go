@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRolloutProgress(t *testing.T) {
+	tests := []struct {
+		name       string
+		generation int64
+		status     appsv1.DeploymentStatus
+		complete   bool
+		stuck      bool
+	}{
+		{
+			name:       "complete",
+			generation: 2,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3,
+			},
+			complete: true,
+		},
+		{
+			name:       "spec not observed",
+			generation: 3,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3,
+			},
+		},
+		{
+			name:       "progress deadline exceeded",
+			generation: 2,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 1, AvailableReplicas: 2,
+				Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentProgressing, Reason: PROGRESS_DEADLINE_EXCEEDED},
+				},
+			},
+			stuck: true,
+		},
+		{
+			name:       "replicas not updated",
+			generation: 2,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 1, AvailableReplicas: 3,
+			},
+		},
+		{
+			name:       "old replicas terminating",
+			generation: 2,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 3, AvailableReplicas: 3,
+			},
+		},
+		{
+			name:       "updated replicas not available",
+			generation: 2,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 2,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Generation: tt.generation},
+				Status:     tt.status,
+			}
+			complete, stuck, message := rolloutProgress(deployment, 3)
+			if complete != tt.complete || stuck != tt.stuck {
+				t.Fatalf("rolloutProgress() = %t, %t (%s), want %t, %t",
+					complete, stuck, message, tt.complete, tt.stuck)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/gogo/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+// Collects the cluster-wide part of the summary sent with heartbeats:
+// server version, node readiness, summed node resources and pod phases.
+func GetClusterSummary(
+	ctx context.Context,
+	client *kubernetes.Clientset,
+) (*pbc.ClusterSummary, error) {
+	version, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server version: %w", err)
+	}
+
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	var ready int32
+	var cpuCapacity, cpuAllocatable, memCapacity, memAllocatable int64
+	for _, n := range nodes.Items {
+		for _, c := range n.Status.Conditions {
+			if c.Type == corev1.NodeReady && c.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+		cpuCapacity += n.Status.Capacity.Cpu().MilliValue()
+		cpuAllocatable += n.Status.Allocatable.Cpu().MilliValue()
+		memCapacity += n.Status.Capacity.Memory().Value()
+		memAllocatable += n.Status.Allocatable.Memory().Value()
+	}
+
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	podsByPhase := make(map[string]int32)
+	for _, p := range pods.Items {
+		podsByPhase[string(p.Status.Phase)]++
+	}
+
+	return &pbc.ClusterSummary{
+		KubernetesVersion: proto.String(version.GitVersion),
+		NodeCount:         proto.Int32(int32(len(nodes.Items))),
+		ReadyNodeCount:    proto.Int32(ready),
+		CpuCapacity:       proto.Int64(cpuCapacity),
+		CpuAllocatable:    proto.Int64(cpuAllocatable),
+		MemoryCapacity:    proto.Int64(memCapacity),
+		MemoryAllocatable: proto.Int64(memAllocatable),
+		PodsByPhase:       podsByPhase,
+	}, nil
+}
@@ -2,11 +2,34 @@
 package deployment
 
 import (
+	"errors"
 	"fmt"
 
 	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	log "github.com/sirupsen/logrus"
 )
 
+const (
+	REMOTE_NAME = "origin"
+)
+
+// Remote deployments repository the agent fetches on its own.
+type Remote struct {
+	// ssh, https, file url or a local path
+	URL        string
+	Branch     string
+	// private key file for ssh urls
+	SSHKeyPath string
+	// username and token for https urls
+	Username   string
+	Token      string
+}
+
 func DeploymentHash(p string) (string, error) {
 	repo, err := gogit.PlainOpen(p)
 	if err != nil {
@@ -19,3 +42,115 @@ func DeploymentHash(p string) (string, error) {
 	return head.Hash().String(), nil
 }
 
+func (r Remote) auth() (transport.AuthMethod, error) {
+	switch {
+	case r.SSHKeyPath != "":
+		keys, err := ssh.NewPublicKeysFromFile("git", r.SSHKeyPath, "")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load SSH key: %v", err)
+		}
+		return keys, nil
+	case r.Token != "":
+		username := r.Username
+		if username == "" {
+			// most hosts only look at the token
+			username = "git"
+		}
+		return &http.BasicAuth{Username: username, Password: r.Token}, nil
+	}
+	return nil, nil
+}
+
+// Clones the remote into p if there is no repository yet, fetches its
+// branches and tags and checks out the commit, or the tip of the branch if
+// commit is empty. Central may pin the agent to a commit of any branch or
+// tag. Local changes in p are discarded.
+func SyncRepository(p string, remote Remote, commit string) error {
+	auth, err := remote.auth()
+	if err != nil {
+		return err
+	}
+	branch := plumbing.NewBranchReferenceName(remote.Branch)
+	remoteBranch := plumbing.NewRemoteReferenceName(REMOTE_NAME, remote.Branch)
+
+	repo, err := gogit.PlainOpen(p)
+	if errors.Is(err, gogit.ErrRepositoryNotExists) {
+		log.Infof("Cloning %s into %s", remote.URL, p)
+		repo, err = gogit.PlainClone(p, false, &gogit.CloneOptions{
+			URL:           remote.URL,
+			Auth:          auth,
+			RemoteName:    REMOTE_NAME,
+			ReferenceName: branch,
+			SingleBranch:  true,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to clone %s: %v", remote.URL, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("Failed to open repository: %v", err)
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("Failed to get worktree: %v", err)
+	}
+	if commit != "" {
+		head, err := repo.Head()
+		if err == nil && head.Hash().String() == commit && isClean(w) {
+			return nil
+		}
+	}
+
+	err = repo.Fetch(&gogit.FetchOptions{
+		RemoteName: REMOTE_NAME,
+		Auth:       auth,
+		RefSpecs:   []config.RefSpec{
+			config.RefSpec("+refs/heads/*:refs/remotes/" + REMOTE_NAME + "/*"),
+			config.RefSpec("+refs/tags/*:refs/tags/*"),
+		},
+		Force:      true,
+	})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("Failed to fetch %s: %v", remote.URL, err)
+	}
+
+	target := plumbing.NewHash(commit)
+	if commit == "" {
+		ref, err := repo.Reference(remoteBranch, true)
+		if err != nil {
+			return fmt.Errorf("Failed to resolve %s: %v", remoteBranch, err)
+		}
+		target = ref.Hash()
+	}
+	if _, err := repo.CommitObject(target); err != nil {
+		return fmt.Errorf("Commit %s is not on any branch or tag of %s: %v", target, remote.URL, err)
+	}
+
+	head, err := repo.Head()
+	if err == nil && head.Hash() == target && isClean(w) {
+		return nil
+	}
+	err = w.Checkout(&gogit.CheckoutOptions{
+		Hash:  target,
+		Force: true,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to check out %s: %v", target, err)
+	}
+	// files added locally would be read along with the checked out ones
+	if err := w.Clean(&gogit.CleanOptions{Dir: true}); err != nil {
+		return fmt.Errorf("Failed to remove untracked files: %v", err)
+	}
+	log.Infof("Checked out %s of %s", target, remote.URL)
+	return nil
+}
+
+// Whether the worktree matches HEAD, without edited or untracked files.
+func isClean(w *gogit.Worktree) bool {
+	status, err := w.Status()
+	if err != nil {
+		log.Warn("Failed to get the worktree status: ", err)
+		return false
+	}
+	return status.IsClean()
+}
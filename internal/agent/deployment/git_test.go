@@ -0,0 +1,196 @@
+package deployment
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Bare repository standing in for the remote, commits are made in a
+// separate worktree and pushed to it.
+type upstream struct {
+	url  string
+	work *gogit.Repository
+	dir  string
+}
+
+func newUpstream(t *testing.T) *upstream {
+	t.Helper()
+	bare := t.TempDir()
+	if _, err := gogit.PlainInit(bare, true); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	work, err := gogit.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = work.CreateRemote(&config.RemoteConfig{Name: REMOTE_NAME, URLs: []string{bare}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &upstream{url: bare, work: work, dir: dir}
+}
+
+// Commits the file to master and pushes it, returns the commit hash.
+func (u *upstream) commit(t *testing.T, file string, content string) string {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(u.dir, file), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := u.work.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add(file); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := w.Commit("update "+file, &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = u.work.Push(&gogit.PushOptions{
+		RemoteName: REMOTE_NAME,
+		RefSpecs:   []config.RefSpec{"refs/heads/master:refs/heads/master"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash.String()
+}
+
+func (u *upstream) remote() Remote {
+	return Remote{URL: u.url, Branch: plumbing.Master.Short()}
+}
+
+func assertCheckedOut(t *testing.T, p string, commit string, file string, content string) {
+	t.Helper()
+	head, err := DeploymentHash(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != commit {
+		t.Fatalf("HEAD is %s, want %s", head, commit)
+	}
+	got, err := os.ReadFile(filepath.Join(p, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Fatalf("%s holds %q, want %q", file, got, content)
+	}
+}
+
+func TestSyncRepositoryClones(t *testing.T) {
+	u := newUpstream(t)
+	first := u.commit(t, "app.yaml", "v1")
+	p := filepath.Join(t.TempDir(), "deployments")
+
+	if err := SyncRepository(p, u.remote(), ""); err != nil {
+		t.Fatalf("first sync failed: %v", err)
+	}
+	assertCheckedOut(t, p, first, "app.yaml", "v1")
+}
+
+func TestSyncRepositoryFetchesNewCommits(t *testing.T) {
+	u := newUpstream(t)
+	u.commit(t, "app.yaml", "v1")
+	p := filepath.Join(t.TempDir(), "deployments")
+	if err := SyncRepository(p, u.remote(), ""); err != nil {
+		t.Fatalf("first sync failed: %v", err)
+	}
+
+	second := u.commit(t, "app.yaml", "v2")
+	if err := SyncRepository(p, u.remote(), ""); err != nil {
+		t.Fatalf("sync to the branch tip failed: %v", err)
+	}
+	assertCheckedOut(t, p, second, "app.yaml", "v2")
+
+	// a pinned commit pushed after the last sync has to be fetched
+	third := u.commit(t, "app.yaml", "v3")
+	if err := SyncRepository(p, u.remote(), third); err != nil {
+		t.Fatalf("sync to a new commit failed: %v", err)
+	}
+	assertCheckedOut(t, p, third, "app.yaml", "v3")
+}
+
+func TestSyncRepositoryChecksOutPinnedCommit(t *testing.T) {
+	u := newUpstream(t)
+	first := u.commit(t, "app.yaml", "v1")
+	u.commit(t, "app.yaml", "v2")
+	p := filepath.Join(t.TempDir(), "deployments")
+
+	if err := SyncRepository(p, u.remote(), first); err != nil {
+		t.Fatalf("sync to a pinned commit failed: %v", err)
+	}
+	assertCheckedOut(t, p, first, "app.yaml", "v1")
+
+	// local changes are discarded
+	if err := os.WriteFile(filepath.Join(p, "app.yaml"), []byte("edited"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SyncRepository(p, u.remote(), ""); err != nil {
+		t.Fatalf("sync back to the branch tip failed: %v", err)
+	}
+	second, err := u.work.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCheckedOut(t, p, second.Hash().String(), "app.yaml", "v2")
+}
+
+// Syncing to the commit already checked out restores edited and removes
+// added files as well.
+func TestSyncRepositoryDiscardsChangesAtHead(t *testing.T) {
+	u := newUpstream(t)
+	first := u.commit(t, "app.yaml", "v1")
+	p := filepath.Join(t.TempDir(), "deployments")
+	if err := SyncRepository(p, u.remote(), first); err != nil {
+		t.Fatalf("first sync failed: %v", err)
+	}
+
+	for _, commit := range []string{first, ""} {
+		if err := os.WriteFile(filepath.Join(p, "app.yaml"), []byte("edited"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(p, "added.yaml"), []byte("added"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := SyncRepository(p, u.remote(), commit); err != nil {
+			t.Fatalf("sync to %q failed: %v", commit, err)
+		}
+		assertCheckedOut(t, p, first, "app.yaml", "v1")
+		if _, err := os.Stat(filepath.Join(p, "added.yaml")); !os.IsNotExist(err) {
+			t.Fatalf("added.yaml was kept by the sync to %q: %v", commit, err)
+		}
+	}
+}
+
+func TestSyncRepositoryUnknownCommit(t *testing.T) {
+	u := newUpstream(t)
+	first := u.commit(t, "app.yaml", "v1")
+	p := filepath.Join(t.TempDir(), "deployments")
+	if err := SyncRepository(p, u.remote(), ""); err != nil {
+		t.Fatalf("first sync failed: %v", err)
+	}
+
+	unknown := strings.Repeat("0123456789", 4)
+	err := SyncRepository(p, u.remote(), unknown)
+	if err == nil {
+		t.Fatalf("sync to unknown commit %s succeeded", unknown)
+	}
+	if !strings.Contains(err.Error(), "is not on any branch or tag") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the previous checkout is left in place
+	assertCheckedOut(t, p, first, "app.yaml", "v1")
+}
@@ -2,22 +2,45 @@ package model
 
 import (
 	"fmt"
+	"os"
+	"slices"
+	"strings"
 	"sync/atomic"
 
 	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"sigs.k8s.io/yaml"
 )
 
 const (
 	AGENT_CONFIG = "agent_config.yaml"
+	AGENT_CERT   = "agent.crt"
+	AGENT_KEY    = "agent.key"
 
 	DEFAULT_HTTPS_CENTRAL      = "https://localhost:10201"
 	DEFAULT_GRPC_CENTRAL       = "localhost:10202"
 	DEFAULT_HTTPS_PORT         = ":10203"
 	DEFAULT_GRPC_PORT          = ":10204"
 	DEFAULT_HEARTBEAT_INTERVAL = 3
+	DEFAULT_SUMMARY_INTERVAL   = 30
+	DEFAULT_DRIFT_INTERVAL     = 60
+	DEFAULT_RECONCILE_INTERVAL = 30
 	DEFAULT_DEPLOYMENT_DIR     = "agent_deployments"
+	DEFAULT_BUNDLE_DIR         = "agent_bundles"
+	DEFAULT_BUNDLE_RETENTION   = 7 * 24 * 60 * 60
+	DEFAULT_NAMESPACE          = "default"
+	// Foreground, Background or Orphan, see metav1.DeletionPropagation
+	DEFAULT_PRUNE_PROPAGATION  = "Background"
+	DEFAULT_GIT_BRANCH         = "main"
+
+	// allowlist entry permitting every namespace
+	ALL_NAMESPACES             = "*"
+
+	// dial: central dials the agent's grpc endpoint
+	// tunnel: the agent keeps a stream open to central, for agents behind NAT
+	CONNECTION_MODE_DIAL       = "dial"
+	CONNECTION_MODE_TUNNEL     = "tunnel"
 
 	// HEARTBEAT_PATH = "/heartbeat"
 	// REGISTER_PATH  = "/register"
@@ -49,6 +72,18 @@ func WriteBackAndReload() error {
 	return nil;
 }
 
+// Forgets the registration so the agent enrolls again on its next start.
+func ClearRegistration() error {
+	viper.Set("registered", false)
+	viper.Set("uuid", "")
+	if err := WriteBackAndReload(); err != nil {
+		return err
+	}
+	config.Store(NewAgentConfig())
+	log.Info("Agent registration cleared, restart the agent to enroll again")
+	return nil
+}
+
 func init() {
 	if err := AgentServerConfigSetup(); err != nil {
 		log.Fatal("Failed to setup agent server config: ", err)
@@ -69,7 +104,36 @@ type AgentConfig struct {
 	HTTPSPort         string `yaml:"https_port"`
 	GRPCPort          string `yaml:"grpc_port"`
 	HeartbeatInterval int    `yaml:"heartbeat_interval"`
+	// Seconds between two collections of the cluster summary
+	SummaryInterval   int    `yaml:"summary_interval"`
+	// Seconds between two comparisons of the applied files with the cluster
+	DriftInterval     int    `yaml:"drift_interval"`
+	// Seconds between two checks of the reconcile assignment and HEAD
+	ReconcileInterval int    `yaml:"reconcile_interval"`
+	// Propagation policy of the deletes when pruning, what happens to dependents
+	PrunePropagation  string `yaml:"prune_propagation"`
 	DeploymentDir     string `yaml:"deployment_dir"`
+	// Remote of the deployments repository, cloned into DeploymentDir and
+	// fetched by the agent. Empty when the directory is synced externally.
+	GitURL            string `yaml:"git_url"`
+	GitBranch         string `yaml:"git_branch"`
+	// Private key file for ssh urls
+	GitSSHKey         string `yaml:"git_ssh_key"`
+	// Username and token for https urls
+	GitUsername       string `yaml:"git_username"`
+	GitToken          string `yaml:"git_token"`
+	// Cache of the bundles shipped by central, keyed by hash
+	BundleDir         string `yaml:"bundle_dir"`
+	// Seconds a cached bundle is kept after its last use
+	BundleRetention   int    `yaml:"bundle_retention"`
+	// Single-use token from `central token create`, cleared after registration
+	BootstrapToken    string `yaml:"bootstrap_token"`
+	ConnectionMode    string `yaml:"connection_mode"`
+	// Namespaces central may operate on, "*" for all of them
+	AllowedNamespaces []string `yaml:"allowed_namespaces"`
+	// Sent to central, which selects agents by them. Keys must be lowercase,
+	// see configLabels
+	Labels            map[string]string `yaml:"labels"`
 }
 
 func NewAgentConfig() *AgentConfig {
@@ -84,8 +148,47 @@ func NewAgentConfig() *AgentConfig {
 		HTTPSPort:         viper.GetString("https_port"),
 		GRPCPort:          viper.GetString("grpc_port"),
 		HeartbeatInterval: viper.GetInt("heartbeat_interval"),
+		SummaryInterval:   viper.GetInt("summary_interval"),
+		DriftInterval:     viper.GetInt("drift_interval"),
+		ReconcileInterval: viper.GetInt("reconcile_interval"),
+		PrunePropagation:  viper.GetString("prune_propagation"),
 		DeploymentDir:     viper.GetString("deployment_dir"),
+		GitURL:            viper.GetString("git_url"),
+		GitBranch:         viper.GetString("git_branch"),
+		GitSSHKey:         viper.GetString("git_ssh_key"),
+		GitUsername:       viper.GetString("git_username"),
+		GitToken:          viper.GetString("git_token"),
+		BundleDir:         viper.GetString("bundle_dir"),
+		BundleRetention:   viper.GetInt("bundle_retention"),
+		BootstrapToken:    viper.GetString("bootstrap_token"),
+		ConnectionMode:    viper.GetString("connection_mode"),
+		AllowedNamespaces: viper.GetStringSlice("allowed_namespaces"),
+		Labels:            configLabels(),
+	}
+}
+
+// Labels of the config file. Viper lowercases the keys of maps when it reads
+// the file and when it writes it back after registration, so a key with
+// capitals would silently change: those are dropped instead.
+func configLabels() map[string]string {
+	labels := viper.GetStringMapString("labels")
+	data, err := os.ReadFile(viper.ConfigFileUsed())
+	if err != nil {
+		return labels
+	}
+	var file struct {
+		Labels map[string]any `json:"labels"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return labels
+	}
+	for key := range file.Labels {
+		if lower := strings.ToLower(key); key != lower {
+			log.Warnf("Dropping label %s, label keys must be lowercase", key)
+			delete(labels, lower)
+		}
 	}
+	return labels
 }
 
 func AgentServerConfigSetup() error {
@@ -104,7 +207,22 @@ func AgentServerConfigSetup() error {
 	viper.SetDefault("uuid", "")
 	viper.SetDefault("registered", false)
 	viper.SetDefault("heartbeat_interval", DEFAULT_HEARTBEAT_INTERVAL)
+	viper.SetDefault("summary_interval", DEFAULT_SUMMARY_INTERVAL)
+	viper.SetDefault("drift_interval", DEFAULT_DRIFT_INTERVAL)
+	viper.SetDefault("reconcile_interval", DEFAULT_RECONCILE_INTERVAL)
+	viper.SetDefault("prune_propagation", DEFAULT_PRUNE_PROPAGATION)
 	viper.SetDefault("deployment_dir", DEFAULT_DEPLOYMENT_DIR)
+	viper.SetDefault("git_url", "")
+	viper.SetDefault("git_branch", DEFAULT_GIT_BRANCH)
+	viper.SetDefault("git_ssh_key", "")
+	viper.SetDefault("git_username", "")
+	viper.SetDefault("git_token", "")
+	viper.SetDefault("bundle_dir", DEFAULT_BUNDLE_DIR)
+	viper.SetDefault("bundle_retention", DEFAULT_BUNDLE_RETENTION)
+	viper.SetDefault("bootstrap_token", "")
+	viper.SetDefault("connection_mode", CONNECTION_MODE_DIAL)
+	viper.SetDefault("allowed_namespaces", []string{DEFAULT_NAMESPACE})
+	viper.SetDefault("labels", map[string]string{})
 
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		log.Info("Config file changed: ", e.Name)
@@ -143,3 +261,11 @@ func AgentServerConfigSetup() error {
 func (c *AgentConfig) GRPCEndpoint() string {
 	return c.Addr + c.GRPCPort
 }
+
+func (c *AgentConfig) AllNamespacesAllowed() bool {
+	return slices.Contains(c.AllowedNamespaces, ALL_NAMESPACES)
+}
+
+func (c *AgentConfig) NamespaceAllowed(namespace string) bool {
+	return c.AllNamespacesAllowed() || slices.Contains(c.AllowedNamespaces, namespace)
+}
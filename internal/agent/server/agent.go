@@ -8,13 +8,17 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/spf13/viper"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
 
 	. "github.com/Coosis/go-k8s-cord/internal"
 	. "github.com/Coosis/go-k8s-cord/internal/agent/cluster"
@@ -23,17 +27,41 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
 )
 
 type AgentServer struct {
 	s      *http.Server
 	gs    *grpc.Server
 
+	centralMu     sync.RWMutex
 	centralConn   *grpc.ClientConn
 
 	k8sClientSet *kubernetes.Clientset
+	// generic apply of any kind
+	k8sDynamic   dynamic.Interface
+	k8sMapper    *restmapper.DeferredDiscoveryRESTMapper
+
+	// files applied through the agent, checked by DriftLoop
+	appliedMu sync.Mutex
+	applied   map[string]appliedFile
+
+	// last reconcile of the assigned paths, see ReconcileLoop
+	reconciled reconcileState
+
+	// last failure to fetch the deployments repository, see git_url
+	fetchMu  sync.RWMutex
+	fetchErr error
+	// held while checking out the deployments directory or reading files
+	// from it, see repository.go
+	repoMu   sync.Mutex
+
+	// cached cluster summary, refreshed by SummaryLoop
+	summaryMu sync.RWMutex
+	summary   *pbc.ClusterSummary
 
 	tlsConfig *tls.Config
+	certs     *CertReloader
 
 	pba.UnimplementedAgentServiceServer
 }
@@ -68,7 +96,8 @@ func NewAgentServer() (*AgentServer, error) {
 		return nil, err
 	}
 
-	cert, err := tls.LoadX509KeyPair("agent.crt", "agent.key")
+	// agent.crt may not exist yet, it is then issued by central on registration
+	certs, err := NewCertReloader(AGENT_CERT, AGENT_KEY)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to load agent certificate and key: %v", err)
 	}
@@ -78,7 +107,8 @@ func NewAgentServer() (*AgentServer, error) {
 		ClientCAs: cas,
 		RootCAs: cas,
 		ClientAuth: tls.RequestClientCert,
-		Certificates: []tls.Certificate{cert},
+		GetCertificate: certs.GetCertificate,
+		GetClientCertificate: certs.GetClientCertificate,
 	}
 
 	// http server bootstrapping
@@ -107,20 +137,51 @@ func NewAgentServer() (*AgentServer, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get Kubernetes client set: %v", err)
 	}
+	dyn, mapper, err := GetDynamicClient()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get Kubernetes dynamic client: %v", err)
+	}
 
 	agentServer := &AgentServer{
 		s:      &s,
 		gs:     grpcServer,
 		centralConn:   conn,
 		k8sClientSet: clientSet,
+		k8sDynamic:   dyn,
+		k8sMapper:    mapper,
+		applied:      map[string]appliedFile{},
 
 		tlsConfig: tlsConfig,
+		certs: certs,
 	}
 	pba.RegisterAgentServiceServer(agentServer.gs, agentServer)
 
 return agentServer, nil
 }
 
+// Connection to central, replaced whenever the agent certificate changes.
+func (s *AgentServer) central() *grpc.ClientConn {
+	s.centralMu.RLock()
+	defer s.centralMu.RUnlock()
+	return s.centralConn
+}
+
+// Drops the connection to central so that the next call does a new
+// handshake, presenting the current certificate.
+func (s *AgentServer) reconnectCentral() error {
+	cfg := GetAgentConfig()
+	cred := credentials.NewTLS(s.tlsConfig)
+	conn, err := grpc.NewClient(cfg.GrpcCentral, grpc.WithTransportCredentials(cred))
+	if err != nil {
+		return fmt.Errorf("Failed to connect to gRPC server: %v", err)
+	}
+	s.centralMu.Lock()
+	old := s.centralConn
+	s.centralConn = conn
+	s.centralMu.Unlock()
+	return old.Close()
+}
+
 func (s *AgentServer) HandleFunc(
 	pat string,
 	f func(http.ResponseWriter, *http.Request),
@@ -162,19 +223,41 @@ func (s *AgentServer) Start(ctx context.Context) error {
 		w.Write([]byte(msg))
 	})
 	s.HandleFunc("/pods/list", func(w http.ResponseWriter, r *http.Request) {
-		pods, err := GetPods(ctx, s.k8sClientSet, "default")
+		resp, err := s.ListPods(ctx, &pba.ListPodsRequest{
+			Namespace:     proto.String(r.URL.Query().Get("namespace")),
+			AllNamespaces: proto.Bool(r.URL.Query().Get("namespace") == ALL_NAMESPACES),
+		})
 		if err != nil {
 			log.Error("Failed to list pods: ", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(pods); err != nil {
+		if err := json.NewEncoder(w).Encode(resp.GetPods()); err != nil {
 			log.Error("Failed to encode pods: ", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 	})
+	s.HandleFunc("/deregister", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// the http server is plain and unauthenticated, only the local cli
+		// may deregister through it
+		if !isLoopback(r.RemoteAddr) {
+			log.Warn("Rejected deregister request from ", r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err := s.Deregister(r.Context()); err != nil {
+			log.Error("Failed to deregister agent: ", err)
+			http.Error(w, "Failed to deregister agent: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
 	if !cfg.Registered {
 		log.Info("Agent not registered, registering...")
 		id, err := s.Register(ctx)
@@ -183,6 +266,8 @@ func (s *AgentServer) Start(ctx context.Context) error {
 		}
 		viper.Set("registered", true)
 		viper.Set("uuid", id)
+		// tokens are single-use
+		viper.Set("bootstrap_token", "")
 		WriteBackAndReload()
 		cfg = GetAgentConfig()
 	}
@@ -200,7 +285,7 @@ func (s *AgentServer) Start(ctx context.Context) error {
 		}
 
 		s.gs.GracefulStop()
-		s.centralConn.Close()
+		s.central().Close()
 		log.Info("gRPC server shutdown successfully")
 	}()
 
@@ -216,19 +301,46 @@ func (s *AgentServer) Start(ctx context.Context) error {
 		return nil
 	})
 	g.Go(func() error {
-		lis, err := net.Listen("tcp", cfg.GRPCPort)
-		if err != nil {
-			return fmt.Errorf("failed to listen: %v", err)
-		}
-		log.Info("Starting agent gRPC server on ", cfg.GRPCPort)
-		if err := s.gs.Serve(lis); err != nil {
-			return err
-		}
-		return nil
+		return s.RenewCertificateLoop(ctx)
+	})
+	g.Go(func() error {
+		return s.SummaryLoop(ctx)
+	})
+	g.Go(func() error {
+		return s.DriftLoop(ctx)
+	})
+	g.Go(func() error {
+		return s.ReconcileLoop(ctx)
 	})
+	if cfg.ConnectionMode == CONNECTION_MODE_TUNNEL {
+		g.Go(func() error {
+			return s.TunnelLoop(ctx)
+		})
+	} else {
+		g.Go(func() error {
+			lis, err := net.Listen("tcp", cfg.GRPCPort)
+			if err != nil {
+				return fmt.Errorf("failed to listen: %v", err)
+			}
+			log.Info("Starting agent gRPC server on ", cfg.GRPCPort)
+			if err := s.gs.Serve(lis); err != nil {
+				return err
+			}
+			return nil
+		})
+	}
 	if err := g.Wait(); err != nil {
 		cancel()
 		return fmt.Errorf("error in agent server: %w", err)
 	}
 	return nil
 }
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,184 @@
+// Bundles of deployment files shipped by central, cached on disk by hash so
+// agents without access to the repository can apply them.
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/Coosis/go-k8s-cord/internal"
+	. "github.com/Coosis/go-k8s-cord/internal/agent/cluster"
+	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+// Loads the files of a request from the bundle, the cached bundle with the
+// hash, or the deployments directory checked out at commit if neither is
+// given. Returns the directory and the commit they were loaded from.
+func (s *AgentServer) loadSource(
+	bundle *pba.Bundle,
+	hash string,
+	commit string,
+	paths []string,
+) (string, string, []Manifest, error) {
+	if bundle == nil && hash == "" {
+		var root, head string
+		var manifests []Manifest
+		err := s.withDeployments(commit, func(dir string) error {
+			root = dir
+			head = deploymentsCommit()
+			manifests = LoadManifests(dir, paths)
+			return nil
+		})
+		if err != nil {
+			return "", "", nil, status.Errorf(codes.Unavailable, "failed to check out the deployments repository: %v", err)
+		}
+		return root, head, manifests, nil
+	}
+
+	root, err := bundleSource(bundle, hash)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if commit == "" {
+		// centrals sending the commit only inside the bundle
+		commit = bundle.GetCommit()
+	}
+	return root, commit, LoadManifests(root, paths), nil
+}
+
+// Directory of the bundle, or of the cached bundle with the hash. The same
+// files may be shipped at several commits, the cache does not know which
+// one a request is at.
+func bundleSource(bundle *pba.Bundle, hash string) (string, error) {
+	cfg := GetAgentConfig()
+	if bundle != nil {
+		return cacheBundle(bundle)
+	}
+
+	if !isBundleHash(hash) {
+		return "", status.Errorf(codes.InvalidArgument, "%s is not a bundle hash", hash)
+	}
+	root := filepath.Join(cfg.BundleDir, hash)
+	if _, err := os.Stat(root); err != nil {
+		return "", status.Errorf(codes.FailedPrecondition, "bundle %s is not cached", hash)
+	}
+	touchBundle(root)
+	return root, nil
+}
+
+// hex encoded SHA-256, anything else could escape the cache directory
+func isBundleHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	return strings.Trim(hash, "0123456789abcdef") == ""
+}
+
+// Verifies the bundle against its hash and extracts it into the cache, once.
+// Returns the directory holding its files.
+func cacheBundle(bundle *pba.Bundle) (string, error) {
+	cfg := GetAgentConfig()
+	files := map[string][]byte{}
+	for _, file := range bundle.GetFiles() {
+		if !filepath.IsLocal(filepath.FromSlash(file.GetPath())) {
+			return "", status.Errorf(codes.InvalidArgument, "%s is outside of the bundle", file.GetPath())
+		}
+		files[file.GetPath()] = file.GetContent()
+	}
+	hash := BundleHash(files)
+	if hash != bundle.GetHash() {
+		return "", status.Errorf(codes.InvalidArgument, "bundle hash %s does not match its content(%s)", bundle.GetHash(), hash)
+	}
+
+	root := filepath.Join(cfg.BundleDir, hash)
+	if _, err := os.Stat(root); err == nil {
+		touchBundle(root)
+		return root, nil
+	}
+	if err := os.MkdirAll(cfg.BundleDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create bundle cache: %w", err)
+	}
+	// extracted next to its final place, then renamed in one go
+	tmp, err := os.MkdirTemp(cfg.BundleDir, ".bundle-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	for path, content := range files {
+		dst := filepath.Join(tmp, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return "", fmt.Errorf("failed to extract %s: %w", path, err)
+		}
+		if err := os.WriteFile(dst, content, 0o644); err != nil {
+			return "", fmt.Errorf("failed to extract %s: %w", path, err)
+		}
+	}
+	if err := os.Rename(tmp, root); err != nil {
+		if _, statErr := os.Stat(root); statErr != nil {
+			return "", fmt.Errorf("failed to cache bundle %s: %w", hash, err)
+		}
+		// cached concurrently by another request
+	}
+	log.Infof("Cached bundle %s of %d files", hash, len(files))
+	return root, nil
+}
+
+// The modification time of a cached bundle is its last use, see pruneBundles.
+func touchBundle(root string) {
+	now := time.Now()
+	if err := os.Chtimes(root, now, now); err != nil {
+		log.Warnf("Failed to mark bundle %s as used: %v", filepath.Base(root), err)
+	}
+}
+
+// Removes the cached bundles not used for bundle_retention seconds, unless
+// a file applied from them is still tracked for drift.
+func (s *AgentServer) pruneBundles() {
+	cfg := GetAgentConfig()
+	retention := cfg.BundleRetention
+	if retention <= 0 {
+		retention = DEFAULT_BUNDLE_RETENTION
+	}
+	entries, err := os.ReadDir(cfg.BundleDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Failed to read the bundle cache: ", err)
+		}
+		return
+	}
+
+	inUse := map[string]bool{}
+	for _, file := range s.appliedFiles() {
+		inUse[file.Root] = true
+	}
+	cutoff := time.Now().Add(-time.Duration(retention) * time.Second)
+	for _, entry := range entries {
+		// .commit files were kept next to the bundles by earlier versions
+		hash := strings.TrimSuffix(entry.Name(), ".commit")
+		if !isBundleHash(hash) {
+			continue
+		}
+		root := filepath.Join(cfg.BundleDir, hash)
+		if inUse[root] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(cfg.BundleDir, entry.Name())); err != nil {
+			log.Warnf("Failed to remove bundle %s: %v", hash, err)
+			continue
+		}
+		log.Infof("Removed bundle %s, unused since %s", hash, info.ModTime().Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,72 @@
+// Renewal of the agent certificate issued by central
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal"
+	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
+
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+const CERT_CHECK_INTERVAL = time.Minute
+
+// Renews the agent certificate once less than a third of its lifetime is
+// left. Blocks until ctx is cancelled.
+func (s *AgentServer) RenewCertificateLoop(ctx context.Context) error {
+	ticker := time.NewTicker(CERT_CHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			leaf := s.certs.Leaf()
+			if leaf == nil {
+				continue
+			}
+			lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+			if time.Until(leaf.NotAfter) > lifetime/3 {
+				continue
+			}
+			log.Info("Agent certificate expires at ", leaf.NotAfter.Format(time.RFC3339), ", renewing...")
+			if err := s.RenewCertificate(ctx); err != nil {
+				log.Error("Failed to renew agent certificate: ", err)
+			}
+		case <-ctx.Done():
+			log.Debug("Stopping certificate renewal...")
+			return nil
+		}
+	}
+}
+
+func (s *AgentServer) RenewCertificate(ctx context.Context) error {
+	cfg := GetAgentConfig()
+	if !cfg.Registered || cfg.UUID == "" {
+		return fmt.Errorf("agent UUID is not set, please register the agent first")
+	}
+	keyPEM, csrPEM, err := GenerateKeyAndCSR(cfg.AgentName, []string{cfg.Addr})
+	if err != nil {
+		return err
+	}
+
+	centralClient := pbc.NewCentralServiceClient(s.central())
+	resp, err := centralClient.RenewCertificate(ctx, &pbc.RenewCertificateRequest{
+		AgentId: proto.String(cfg.UUID),
+		Csr: csrPEM,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to renew certificate: %w", err)
+	}
+	if err := WriteKeyPair(AGENT_CERT, AGENT_KEY, resp.GetCertificate(), keyPEM); err != nil {
+		return err
+	}
+	log.Info("Agent certificate renewed")
+
+	// central now only accepts the new certificate
+	return s.reconnectCentral()
+}
@@ -0,0 +1,80 @@
+// Deregistration, either requested by central(Decommission) or initiated
+// locally through the agent's http server(loopback only).
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/agent/cluster"
+	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+func(s *AgentServer) Decommission(
+	ctx context.Context,
+	req *pba.DecommissionRequest,
+) (*pba.DecommissionResponse, error) {
+	log.Info("Decommission requested by central")
+	drained := []string{}
+	if req.GetDrain() {
+		var err error
+		drained, err = s.drain(ctx, req.GetNamespace())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := ClearRegistration(); err != nil {
+		return nil, err
+	}
+
+	return &pba.DecommissionResponse{
+		Success: proto.Bool(true),
+		Drained: drained,
+	}, nil
+}
+
+// Removes the agent from central, then forgets the registration locally.
+// Workloads are left alone, draining is only done when central asks for it
+// through Decommission.
+func(s *AgentServer) Deregister(ctx context.Context) error {
+	cfg := GetAgentConfig()
+	if !cfg.Registered || cfg.UUID == "" {
+		return fmt.Errorf("agent is not registered")
+	}
+
+	centralClient := pbc.NewCentralServiceClient(s.central())
+	resp, err := centralClient.DeregisterAgent(ctx, &pbc.DeregisterAgentRequest{
+		AgentId: proto.String(cfg.UUID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deregister agent: %w", err)
+	}
+	if !resp.GetSuccess() {
+		return fmt.Errorf("deregister failed, remote: %s", resp.GetMessage())
+	}
+
+	return ClearRegistration()
+}
+
+// Drains the given namespace, or every allowed namespace when empty.
+func(s *AgentServer) drain(ctx context.Context, namespace string) ([]string, error) {
+	namespaces, err := listNamespaces(namespace, namespace == "")
+	if err != nil {
+		return nil, err
+	}
+	drained := []string{}
+	for _, namespace := range namespaces {
+		removed, err := DrainManagedDeployments(ctx, s.k8sClientSet, namespace)
+		drained = append(drained, removed...)
+		if err != nil {
+			return drained, err
+		}
+	}
+	return drained, nil
+}
@@ -3,8 +3,13 @@ package server
 import (
 	"context"
 	"path/filepath"
+	"slices"
 
 	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	. "github.com/Coosis/go-k8s-cord/internal/agent/cluster"
 	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
@@ -16,10 +21,18 @@ func(s *AgentServer) ListDeployments(
 	ctx context.Context,
 	req *pba.ListDeploymentsRequest,
 ) (*pba.ListDeploymentsResponse, error) {
-	deployments, err := GetDeployments(ctx, s.k8sClientSet, "default")
+	namespaces, err := listNamespaces(req.GetNamespace(), req.GetAllNamespaces())
 	if err != nil {
 		return nil, err
 	}
+	deployments := []*pba.DeploymentMetadata{}
+	for _, namespace := range namespaces {
+		found, err := GetDeployments(ctx, s.k8sClientSet, namespace)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, found...)
+	}
 
 	return &pba.ListDeploymentsResponse{
 		Deployments: deployments,
@@ -30,19 +43,53 @@ func(s *AgentServer) ApplyDeployments(
 	ctx context.Context,
 	req *pba.ApplyDeploymentsRequest,
 ) (*pba.ApplyDeploymentsResponse, error) {
-	cfg := GetAgentConfig()
-	paths := make([]string, 0, len(req.DeploymentName))
+	namespace, err := resolveNamespace(req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
 	for _, deployment := range req.DeploymentName {
-		path := filepath.Join(cfg.DeploymentDir, deployment)
-		paths = append(paths, path)
+		if !filepath.IsLocal(deployment) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is outside of the deployments directory", deployment)
+		}
 	}
-	err := ApplyDeployments(ctx, s.k8sClientSet, "default", paths)
+	root, commit, manifests, err := s.loadSource(req.GetBundle(), req.GetBundleHash(), req.GetCommit(), req.DeploymentName)
 	if err != nil {
 		return nil, err
 	}
+	LabelManifests(manifests, commit)
+	objects := ApplyManifests(ctx, s.k8sDynamic, s.k8sMapper, namespace, allowObjectNamespace, manifests)
+	s.trackApplied(root, namespace, commit, objects)
 
 	return &pba.ApplyDeploymentsResponse{
-		Success: proto.Bool(true),
+		Success: proto.Bool(allSucceeded(objects)),
+		Objects: objects,
+		Commit:  proto.String(commit),
+	}, nil
+}
+
+func(s *AgentServer) DiffDeployments(
+	ctx context.Context,
+	req *pba.DiffDeploymentsRequest,
+) (*pba.DiffDeploymentsResponse, error) {
+	namespace, err := resolveNamespace(req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	for _, deployment := range req.DeploymentName {
+		if !filepath.IsLocal(deployment) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is outside of the deployments directory", deployment)
+		}
+	}
+	_, commit, manifests, err := s.loadSource(req.GetBundle(), req.GetBundleHash(), req.GetCommit(), req.DeploymentName)
+	if err != nil {
+		return nil, err
+	}
+	LabelManifests(manifests, commit)
+	objects := DiffManifests(ctx, s.k8sDynamic, s.k8sMapper, namespace, allowObjectNamespace, manifests)
+
+	return &pba.DiffDeploymentsResponse{
+		Success: proto.Bool(allSucceeded(objects)),
+		Objects: objects,
 	}, nil
 }
 
@@ -50,20 +97,83 @@ func(s *AgentServer) RemoveDeployments(
 	ctx context.Context,
 	req *pba.RemoveDeploymentsRequest,
 ) (*pba.RemoveDeploymentsResponse, error) {
-	paths := make([]string, 0, len(req.DeploymentName))
-	for _, deployment := range req.DeploymentName {
-		paths = append(paths, deployment)
-	}
-	err := RemoveDeployments(ctx, s.k8sClientSet, "default", paths)
+	namespace, err := resolveNamespace(req.GetNamespace())
 	if err != nil {
 		return nil, err
 	}
+	objects := RemoveDeployments(ctx, s.k8sClientSet, namespace, req.DeploymentName)
+	s.untrackRemoved(objects)
 
 	return &pba.RemoveDeploymentsResponse{
-		Success: proto.Bool(true),
+		Success: proto.Bool(allSucceeded(objects)),
+		Objects: objects,
 	}, nil
 }
 
+func(s *AgentServer) PruneApplied(
+	ctx context.Context,
+	req *pba.PruneAppliedRequest,
+) (*pba.PruneAppliedResponse, error) {
+	if req.GetCommit() == "" {
+		return nil, status.Error(codes.InvalidArgument, "commit is required")
+	}
+	for _, path := range req.GetPaths() {
+		if !filepath.IsLocal(path) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is outside of the deployments directory", path)
+		}
+	}
+	applied, err := ListAppliedObjects(ctx, s.k8sClientSet.Discovery(), s.k8sDynamic, CommitSelector(req.GetCommit()), pruneNamespaces())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list applied objects: %v", err)
+	}
+
+	stale := []unstructured.Unstructured{}
+	for _, obj := range applied {
+		file := obj.GetAnnotations()[SOURCE_FILE_ANNOTATION]
+		if slices.Contains(req.GetKeep(), file) {
+			continue
+		}
+		if slices.ContainsFunc(req.GetPaths(), func(path string) bool {
+			return underPath(file, path)
+		}) {
+			stale = append(stale, obj)
+		}
+	}
+	log.Infof("Pruning %d objects applied at %s", len(stale), req.GetCommit())
+	objects := PruneObjects(ctx, s.k8sDynamic, s.k8sMapper, stale, prunePropagation())
+	s.untrackRemoved(objects)
+
+	return &pba.PruneAppliedResponse{
+		Success: proto.Bool(allSucceeded(objects)),
+		Objects: objects,
+	}, nil
+}
+
+// Whether the file is the path or in the directory at path.
+func underPath(file string, path string) bool {
+	rel, err := filepath.Rel(path, file)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// HEAD of the deployments directory, empty when it is not a git repository.
+func deploymentsCommit() string {
+	commit, err := DeploymentHash(GetAgentConfig().DeploymentDir)
+	if err != nil {
+		log.Warn("Failed to get the deployments commit, objects are applied without it: ", err)
+		return ""
+	}
+	return commit
+}
+
+func allSucceeded(objects []*pba.ObjectResult) bool {
+	for _, obj := range objects {
+		if obj.GetStatus() == pba.ObjectStatus_FAILED {
+			return false
+		}
+	}
+	return true
+}
+
 func(s *AgentServer) GetDeploymentsHash(
 	ctx context.Context,
 	req *pba.GetDeploymentsHashRequest,
@@ -0,0 +1,223 @@
+// Drift detection: the files applied through the agent are dry-run again
+// periodically, objects that would change have drifted from the repository.
+package server
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/agent/cluster"
+	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+// A file applied through the agent, kept in memory until it is removed.
+// Central sends back the files it recorded, so they survive a restart.
+type appliedFile struct {
+	// directory the file was loaded from, a cached bundle or the deployments
+	// directory if empty
+	Root      string
+	// namespace the file was applied with
+	Namespace string
+	// deployments commit the file was applied at
+	Commit    string
+	// kind/namespace/name of its objects
+	Objects   []string
+}
+
+// Loads the file from where it was applied from, the deployments repository
+// is checked out at the commit it was applied at.
+func (s *AgentServer) loadApplied(name string, f appliedFile) ([]Manifest, error) {
+	if f.Root != "" && f.Root != GetAgentConfig().DeploymentDir {
+		return LoadManifests(f.Root, []string{name}), nil
+	}
+	var manifests []Manifest
+	err := s.withDeployments(f.Commit, func(root string) error {
+		manifests = LoadManifests(root, []string{name})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check out %s: %w", f.Commit, err)
+	}
+	return manifests, nil
+}
+
+func objectRef(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// Records the files of the applied objects for drift detection.
+func (s *AgentServer) trackApplied(root string, namespace string, commit string, results []*pba.ObjectResult) {
+	s.appliedMu.Lock()
+	defer s.appliedMu.Unlock()
+	for _, result := range results {
+		if result.GetStatus() == pba.ObjectStatus_FAILED || result.GetFile() == "" {
+			continue
+		}
+		file := s.applied[result.GetFile()]
+		if file.Commit != commit {
+			// objects of the previous apply are not known to be in the file anymore
+			file.Objects = nil
+		}
+		file.Root = root
+		file.Namespace = namespace
+		file.Commit = commit
+		ref := objectRef(result.GetKind(), result.GetNamespace(), result.GetName())
+		if !slices.Contains(file.Objects, ref) {
+			file.Objects = append(file.Objects, ref)
+		}
+		s.applied[result.GetFile()] = file
+	}
+}
+
+// Stops tracking the files of removed objects.
+func (s *AgentServer) untrackRemoved(results []*pba.ObjectResult) {
+	s.appliedMu.Lock()
+	defer s.appliedMu.Unlock()
+	for _, result := range results {
+		if result.GetStatus() != pba.ObjectStatus_REMOVED {
+			continue
+		}
+		ref := objectRef(result.GetKind(), result.GetNamespace(), result.GetName())
+		maps.DeleteFunc(s.applied, func(_ string, file appliedFile) bool {
+			return slices.Contains(file.Objects, ref)
+		})
+	}
+}
+
+// Tracks the files central recorded that the agent does not know about.
+func (s *AgentServer) trackDesired(desired []*pbc.DesiredFile) {
+	s.appliedMu.Lock()
+	defer s.appliedMu.Unlock()
+	for _, file := range desired {
+		if _, ok := s.applied[file.GetFile()]; ok {
+			continue
+		}
+		namespace, err := resolveNamespace(file.GetNamespace())
+		if err != nil {
+			log.Warnf("Not tracking %s recorded by central: %v", file.GetFile(), err)
+			continue
+		}
+		root := ""
+		if hash := file.GetBundleHash(); hash != "" {
+			root, err = bundleSource(nil, hash)
+			if err != nil {
+				log.Warnf("Not tracking %s recorded by central: %v", file.GetFile(), err)
+				continue
+			}
+		}
+		log.Debugf("Tracking %s, recorded by central", file.GetFile())
+		s.applied[file.GetFile()] = appliedFile{
+			Root:      root,
+			Namespace: namespace,
+			Commit:    file.GetCommit(),
+			Objects:   file.GetObjects(),
+		}
+	}
+}
+
+func (s *AgentServer) appliedFiles() map[string]appliedFile {
+	s.appliedMu.Lock()
+	defer s.appliedMu.Unlock()
+	return maps.Clone(s.applied)
+}
+
+func (s *AgentServer) DriftLoop(ctx context.Context) error {
+	for {
+		interval := GetAgentConfig().DriftInterval
+		if interval <= 0 {
+			interval = DEFAULT_DRIFT_INTERVAL
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
+
+		if err := s.CheckDrift(ctx); err != nil {
+			log.Error("Drift check failed: ", err)
+		}
+		s.pruneBundles()
+	}
+}
+
+// Compares every applied file against the live cluster, reports the drifted
+// objects to central and re-applies the drifted files central asks to heal.
+func (s *AgentServer) CheckDrift(ctx context.Context) error {
+	cfg := GetAgentConfig()
+	if !cfg.Registered || cfg.UUID == "" {
+		return nil
+	}
+
+	files := s.appliedFiles()
+	checked := []string{}
+	drifted := []*pbc.DriftedObject{}
+	driftedFiles := map[string]bool{}
+	for _, file := range slices.Sorted(maps.Keys(files)) {
+		manifests, err := s.loadApplied(file, files[file])
+		if err != nil {
+			// not reported as checked, the file stays pending on central
+			log.Warnf("Skipping drift check of %s: %v", file, err)
+			continue
+		}
+		checked = append(checked, file)
+		LabelManifests(manifests, files[file].Commit)
+		results := DiffManifests(ctx, s.k8sDynamic, s.k8sMapper, files[file].Namespace, allowObjectNamespace, manifests)
+		for _, result := range results {
+			if result.GetStatus() == pba.ObjectStatus_UNCHANGED {
+				continue
+			}
+			driftedFiles[file] = true
+			drifted = append(drifted, &pbc.DriftedObject{
+				File:       proto.String(file),
+				ApiVersion: proto.String(result.GetApiVersion()),
+				Kind:       proto.String(result.GetKind()),
+				Namespace:  proto.String(result.GetNamespace()),
+				Name:       proto.String(result.GetName()),
+				Diff:       proto.String(result.GetDiff()),
+				Error:      proto.String(result.GetError()),
+			})
+		}
+	}
+	if len(drifted) > 0 {
+		log.Warnf("%d objects drifted from the deployments repository", len(drifted))
+	}
+
+	centralClient := pbc.NewCentralServiceClient(s.central())
+	resp, err := centralClient.ReportDrift(ctx, &pbc.ReportDriftRequest{
+		AgentId:      proto.String(cfg.UUID),
+		CheckedFiles: checked,
+		Objects:      drifted,
+		Timestamp:    proto.Int64(time.Now().Unix()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to report drift: %w", err)
+	}
+	s.trackDesired(resp.GetDesired())
+
+	for _, file := range resp.GetSelfHeal() {
+		if !driftedFiles[file] {
+			continue
+		}
+		log.Infof("Self-healing %s", file)
+		manifests, err := s.loadApplied(file, files[file])
+		if err != nil {
+			log.Errorf("Self-heal of %s failed: %v", file, err)
+			continue
+		}
+		LabelManifests(manifests, files[file].Commit)
+		results := ApplyManifests(ctx, s.k8sDynamic, s.k8sMapper, files[file].Namespace, allowObjectNamespace, manifests)
+		if !allSucceeded(results) {
+			log.Errorf("Self-heal of %s failed for some objects", file)
+		}
+	}
+	return nil
+}
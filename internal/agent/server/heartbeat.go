@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
 
 	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
@@ -13,20 +15,31 @@ import (
 
 func (s *AgentServer) Heartbeat(ctx context.Context) error {
 	cfg := GetAgentConfig()
-	if cfg.UUID == "" {
+	if !cfg.Registered || cfg.UUID == "" {
 		return fmt.Errorf("agent UUID is not set, please register the agent first")
 	}
 	grpcEndpoint := cfg.GRPCEndpoint()
 	if grpcEndpoint == "" {
 		return fmt.Errorf("GRPC endpoint is not set in the config file, please edit the config file and set the endpoint")
 	}
-	centralClient := pbc.NewCentralServiceClient(s.centralConn)
+	centralClient := pbc.NewCentralServiceClient(s.central())
 	resp, err := centralClient.SendHeartbeat(ctx, &pbc.HeartbeatRequest{
 		AgentId: proto.String(cfg.UUID),
 		AgentName: proto.String(cfg.AgentName),
 		Timestamp: proto.Int64(time.Now().Unix()),
 		AgentGrpcEndpoint: proto.String(grpcEndpoint),
+		AgentVersion: proto.String(cfg.Version),
+		ConnectionMode: proto.String(cfg.ConnectionMode),
+		Summary: s.Summary(),
+		Labels: cfg.Labels,
 	})
+	if status.Code(err) == codes.NotFound {
+		// central no longer knows about this agent
+		if err := ClearRegistration(); err != nil {
+			return fmt.Errorf("failed to clear registration: %w", err)
+		}
+		return fmt.Errorf("agent is not registered on central anymore: %w", err)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to send heartbeat: %w", err)
 	}
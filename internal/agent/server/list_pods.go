@@ -8,10 +8,18 @@ import (
 )
 
 func(s *AgentServer) ListPods(ctx context.Context, req *pba.ListPodsRequest) (*pba.ListPodsResponse, error) {
-	pods, err := GetPods(ctx, s.k8sClientSet, "default")
+	namespaces, err := listNamespaces(req.GetNamespace(), req.GetAllNamespaces())
 	if err != nil {
 		return nil, err
 	}
+	pods := []*pba.PodMetadata{}
+	for _, namespace := range namespaces {
+		found, err := GetPods(ctx, s.k8sClientSet, namespace)
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, found...)
+	}
 
 	return &pba.ListPodsResponse{
 		Pods: pods,
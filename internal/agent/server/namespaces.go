@@ -0,0 +1,80 @@
+// Namespace resolution against the allowlist in the agent config.
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+// Defaults an empty namespace and refuses the ones central may not touch.
+func resolveNamespace(namespace string) (string, error) {
+	cfg := GetAgentConfig()
+	if namespace == "" {
+		namespace = DEFAULT_NAMESPACE
+	}
+	if namespace == ALL_NAMESPACES {
+		return "", status.Error(codes.InvalidArgument, "a single namespace is required")
+	}
+	if !cfg.NamespaceAllowed(namespace) {
+		return "", status.Errorf(codes.PermissionDenied, "namespace %s is not allowed on this agent", namespace)
+	}
+	return namespace, nil
+}
+
+// Namespace check for applied objects, cluster-scoped objects(no namespace)
+// need every namespace to be allowed.
+func allowObjectNamespace(namespace string) bool {
+	cfg := GetAgentConfig()
+	if namespace == metav1.NamespaceAll {
+		return cfg.AllNamespacesAllowed()
+	}
+	return cfg.NamespaceAllowed(namespace)
+}
+
+// Namespaces a listing covers, metav1.NamespaceAll stands for the whole
+// cluster when the allowlist permits it.
+func listNamespaces(namespace string, all bool) ([]string, error) {
+	cfg := GetAgentConfig()
+	if !all {
+		namespace, err := resolveNamespace(namespace)
+		if err != nil {
+			return nil, err
+		}
+		return []string{namespace}, nil
+	}
+	if cfg.AllNamespacesAllowed() {
+		return []string{metav1.NamespaceAll}, nil
+	}
+	return cfg.AllowedNamespaces, nil
+}
+
+func(s *AgentServer) ListNamespaces(
+	ctx context.Context,
+	req *pba.ListNamespacesRequest,
+) (*pba.ListNamespacesResponse, error) {
+	cfg := GetAgentConfig()
+	if !cfg.AllNamespacesAllowed() {
+		return &pba.ListNamespacesResponse{
+			Namespaces: cfg.AllowedNamespaces,
+		}, nil
+	}
+
+	namespaces, err := s.k8sClientSet.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		names = append(names, ns.Name)
+	}
+	return &pba.ListNamespacesResponse{
+		Namespaces: names,
+	}, nil
+}
@@ -0,0 +1,91 @@
+// grpc implementation for scaling, restarting, pausing and resuming a single
+// deployment
+package server
+
+import (
+	"context"
+
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/Coosis/go-k8s-cord/internal/agent/cluster"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+func(s *AgentServer) ScaleDeployment(
+	ctx context.Context,
+	req *pba.ScaleDeploymentRequest,
+) (*pba.ScaleDeploymentResponse, error) {
+	namespace, err := resolveNamespace(req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetReplicas() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "replicas must not be negative, got %d", req.GetReplicas())
+	}
+	previous, err := ScaleDeployment(ctx, s.k8sClientSet, namespace, req.GetName(), req.GetReplicas())
+	if err != nil {
+		return nil, deploymentError(err)
+	}
+
+	return &pba.ScaleDeploymentResponse{
+		PreviousReplicas: proto.Int32(previous),
+		Replicas:         proto.Int32(req.GetReplicas()),
+	}, nil
+}
+
+func(s *AgentServer) RestartDeployment(
+	ctx context.Context,
+	req *pba.RestartDeploymentRequest,
+) (*pba.RestartDeploymentResponse, error) {
+	namespace, err := resolveNamespace(req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	restartedAt, err := RestartDeployment(ctx, s.k8sClientSet, namespace, req.GetName())
+	if err != nil {
+		return nil, deploymentError(err)
+	}
+
+	return &pba.RestartDeploymentResponse{
+		RestartedAt: proto.String(restartedAt),
+	}, nil
+}
+
+func(s *AgentServer) PauseDeployment(
+	ctx context.Context,
+	req *pba.PauseDeploymentRequest,
+) (*pba.PauseDeploymentResponse, error) {
+	namespace, err := resolveNamespace(req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	changed, err := SetDeploymentPaused(ctx, s.k8sClientSet, namespace, req.GetName(), true)
+	if err != nil {
+		return nil, deploymentError(err)
+	}
+
+	return &pba.PauseDeploymentResponse{
+		Changed: proto.Bool(changed),
+	}, nil
+}
+
+func(s *AgentServer) ResumeDeployment(
+	ctx context.Context,
+	req *pba.ResumeDeploymentRequest,
+) (*pba.ResumeDeploymentResponse, error) {
+	namespace, err := resolveNamespace(req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	changed, err := SetDeploymentPaused(ctx, s.k8sClientSet, namespace, req.GetName(), false)
+	if err != nil {
+		return nil, deploymentError(err)
+	}
+
+	return &pba.ResumeDeploymentResponse{
+		Changed: proto.Bool(changed),
+	}, nil
+}
@@ -0,0 +1,271 @@
+// GitOps mode, the cluster follows the repository paths central assigned to
+// the agent, see GetAssignment on central.
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	. "github.com/Coosis/go-k8s-cord/internal/agent/cluster"
+	. "github.com/Coosis/go-k8s-cord/internal/agent/deployment"
+	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+const (
+	// same values as RECONCILE_STATUS_* on central
+	RECONCILE_STATUS_SYNCED = "synced"
+	RECONCILE_STATUS_FAILED = "failed"
+)
+
+// What the last reconcile did, only touched by ReconcileLoop.
+type reconcileState struct {
+	Commit    string
+	Paths     []string
+	Namespace string
+	// set when the reconcile has to run again even if nothing changed
+	Failed    bool
+}
+
+// group/kind/namespace/name, stable across api versions of a kind
+func objectID(apiVersion string, kind string, namespace string, name string) string {
+	gk := schema.FromAPIVersionAndKind(apiVersion, kind).GroupKind()
+	return gk.String() + "/" + namespace + "/" + name
+}
+
+// Propagation policy from the config, the default one if it is unknown.
+func prunePropagation() metav1.DeletionPropagation {
+	policy := metav1.DeletionPropagation(GetAgentConfig().PrunePropagation)
+	switch policy {
+	case metav1.DeletePropagationForeground,
+		metav1.DeletePropagationBackground,
+		metav1.DeletePropagationOrphan:
+		return policy
+	}
+	log.Warnf("Unknown prune_propagation %q, using %s", policy, DEFAULT_PRUNE_PROPAGATION)
+	return DEFAULT_PRUNE_PROPAGATION
+}
+
+// Namespaces objects are pruned in, metav1.NamespaceAll when every
+// namespace is allowed.
+func pruneNamespaces() []string {
+	cfg := GetAgentConfig()
+	if cfg.AllNamespacesAllowed() {
+		return []string{metav1.NamespaceAll}
+	}
+	return cfg.AllowedNamespaces
+}
+
+// Deletes the objects owned by the reconcile loop that were not applied by
+// the current reconcile, found through their labels so nothing is missed
+// across restarts. current holds the objectID of the applied objects.
+func (s *AgentServer) pruneReconciled(ctx context.Context, current map[string]bool) ([]*pba.ObjectResult, error) {
+	owned, err := ListAppliedObjects(ctx, s.k8sClientSet.Discovery(), s.k8sDynamic, ReconciledSelector(), pruneNamespaces())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reconciled objects: %w", err)
+	}
+
+	stale := []unstructured.Unstructured{}
+	for _, obj := range owned {
+		if !current[objectID(obj.GetAPIVersion(), obj.GetKind(), obj.GetNamespace(), obj.GetName())] {
+			stale = append(stale, obj)
+		}
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+	log.Infof("Pruning %d objects that left the assigned paths", len(stale))
+	pruned := PruneObjects(ctx, s.k8sDynamic, s.k8sMapper, stale, prunePropagation())
+	s.untrackRemoved(pruned)
+	return pruned, nil
+}
+
+func (s *AgentServer) ReconcileLoop(ctx context.Context) error {
+	for {
+		interval := GetAgentConfig().ReconcileInterval
+		if interval <= 0 {
+			interval = DEFAULT_RECONCILE_INTERVAL
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
+
+		if err := s.Reconcile(ctx); err != nil {
+			log.Error("Reconcile failed: ", err)
+		}
+	}
+}
+
+// Applies the assigned paths whenever HEAD or the assignment changes, and
+// prunes the objects that left them. The outcome is reported to central.
+func (s *AgentServer) Reconcile(ctx context.Context) error {
+	cfg := GetAgentConfig()
+	if !cfg.Registered || cfg.UUID == "" {
+		return nil
+	}
+
+	centralClient := pbc.NewCentralServiceClient(s.central())
+	assignment, err := centralClient.GetAssignment(ctx, &pbc.GetAssignmentRequest{
+		AgentId: proto.String(cfg.UUID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get the reconcile assignment: %w", err)
+	}
+	if !assignment.GetEnabled() {
+		// keeps the directory at the commit central asks for anyway, for
+		// the files applied from the agent page
+		s.withDeployments(assignment.GetCommit(), func(string) error { return nil })
+		if s.reconciled.Commit != "" {
+			log.Info("Reconciliation turned off, applied objects are left in place")
+		}
+		s.reconciled = reconcileState{}
+		return nil
+	}
+
+	paths := slices.Sorted(slices.Values(assignment.GetPaths()))
+	namespace, err := resolveNamespace(assignment.GetNamespace())
+	if err != nil {
+		return s.reportReconcile(ctx, "", "", err, nil, nil)
+	}
+	for _, path := range paths {
+		if !filepath.IsLocal(path) {
+			return s.reportReconcile(ctx, "", namespace, fmt.Errorf("%s is outside of the deployments directory", path), nil, nil)
+		}
+	}
+
+	// the files are read at the commit they are labeled with, nothing is
+	// checked out in between
+	var commit string
+	var manifests []Manifest
+	err = s.withDeployments(assignment.GetCommit(), func(root string) error {
+		head, err := DeploymentHash(root)
+		if err != nil {
+			return fmt.Errorf("failed to get the deployments commit: %w", err)
+		}
+		commit = head
+		last := s.reconciled
+		if !last.Failed &&
+			last.Commit == commit &&
+			last.Namespace == namespace &&
+			slices.Equal(last.Paths, paths) {
+			return nil
+		}
+		manifests = LoadDesiredManifests(root, paths)
+		return nil
+	})
+	if err != nil {
+		return s.reportReconcile(ctx, commit, namespace, err, nil, nil)
+	}
+	if manifests == nil {
+		return nil
+	}
+
+	log.Infof("Reconciling %v at %s", paths, commit)
+	LabelManifests(manifests, commit)
+	MarkReconciled(manifests)
+	applied := ApplyManifests(ctx, s.k8sDynamic, s.k8sMapper, namespace, allowObjectNamespace, manifests)
+	s.trackApplied(cfg.DeploymentDir, namespace, commit, applied)
+
+	current := map[string]bool{}
+	for _, result := range applied {
+		current[objectID(result.GetApiVersion(), result.GetKind(), result.GetNamespace(), result.GetName())] = true
+	}
+	pruned := []*pba.ObjectResult{}
+	var pruneErr error
+	if !allSucceeded(applied) {
+		// objects of unreadable files or failed applies are not known for
+		// sure, nothing is pruned until the next reconcile
+		log.Warn("Some objects failed to apply, skipping the prune")
+	} else {
+		pruned, pruneErr = s.pruneReconciled(ctx, current)
+	}
+
+	s.reconciled = reconcileState{
+		Commit:    commit,
+		Paths:     paths,
+		Namespace: namespace,
+		Failed:    pruneErr != nil || !allSucceeded(applied) || !allSucceeded(pruned),
+	}
+	reconcileErr := pruneErr
+	if reconcileErr == nil && s.reconciled.Failed {
+		reconcileErr = firstFailure(slices.Concat(applied, pruned))
+	}
+	return s.reportReconcile(ctx, commit, namespace, reconcileErr, applied, pruned)
+}
+
+// Summarizes the failed objects, nil if there are none.
+func firstFailure(results []*pba.ObjectResult) error {
+	failed := []*pba.ObjectResult{}
+	for _, result := range results {
+		if result.GetStatus() == pba.ObjectStatus_FAILED {
+			failed = append(failed, result)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	first := failed[0]
+	return fmt.Errorf("%d objects failed, first %s %s from %s: %s",
+		len(failed), first.GetKind(), first.GetName(), first.GetFile(), first.GetError())
+}
+
+func (s *AgentServer) reportReconcile(
+	ctx context.Context,
+	commit string,
+	namespace string,
+	reconcileErr error,
+	applied []*pba.ObjectResult,
+	pruned []*pba.ObjectResult,
+) error {
+	cfg := GetAgentConfig()
+	req := &pbc.ReportReconcileRequest{
+		AgentId:   proto.String(cfg.UUID),
+		Commit:    proto.String(commit),
+		Status:    proto.String(RECONCILE_STATUS_SYNCED),
+		Timestamp: proto.Int64(time.Now().Unix()),
+		Namespace: proto.String(namespace),
+	}
+	if reconcileErr != nil {
+		log.Error("Reconcile failed: ", reconcileErr)
+		s.reconciled.Failed = true
+		req.Status = proto.String(RECONCILE_STATUS_FAILED)
+		req.Error = proto.String(reconcileErr.Error())
+	}
+
+	counts := map[pba.ObjectStatus]int32{}
+	for _, result := range slices.Concat(applied, pruned) {
+		counts[result.GetStatus()]++
+		req.Objects = append(req.Objects, &pbc.ReconciledObject{
+			File:       proto.String(result.GetFile()),
+			ApiVersion: proto.String(result.GetApiVersion()),
+			Kind:       proto.String(result.GetKind()),
+			Namespace:  proto.String(result.GetNamespace()),
+			Name:       proto.String(result.GetName()),
+			Status:     proto.String(result.GetStatus().String()),
+			Error:      proto.String(result.GetError()),
+		})
+	}
+	req.Applied = proto.Int32(counts[pba.ObjectStatus_APPLIED])
+	req.Unchanged = proto.Int32(counts[pba.ObjectStatus_UNCHANGED])
+	req.Failed = proto.Int32(counts[pba.ObjectStatus_FAILED])
+	req.Pruned = proto.Int32(counts[pba.ObjectStatus_REMOVED])
+
+	centralClient := pbc.NewCentralServiceClient(s.central())
+	if _, err := centralClient.ReportReconcile(ctx, req); err != nil {
+		return fmt.Errorf("failed to report reconcile: %w", err)
+	}
+	return nil
+}
@@ -5,6 +5,10 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal"
 	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
 
 	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
@@ -12,9 +16,29 @@ import (
 
 func (s *AgentServer) Register(ctx context.Context) (string, error) {
 	cfg := GetAgentConfig()
-	centralClient := pbc.NewCentralServiceClient(s.centralConn)
+	if cfg.BootstrapToken == "" {
+		return "", fmt.Errorf("bootstrap_token is not set in the config file, " +
+			"create one with `central token create`")
+	}
+	// without a certificate, central issues one from our CSR
+	var keyPEM, csrPEM []byte
+	if s.certs.Certificate() == nil {
+		log.Info("No agent certificate found, requesting one from central")
+		var err error
+		keyPEM, csrPEM, err = GenerateKeyAndCSR(cfg.AgentName, []string{cfg.Addr})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	centralClient := pbc.NewCentralServiceClient(s.central())
 	resp, err := centralClient.RegisterAgent(ctx, &pbc.RegisterAgentRequest{
-		AgentGrpcEndpoint: &cfg.Addr,
+		AgentGrpcEndpoint: proto.String(cfg.GRPCEndpoint()),
+		AgentName: &cfg.AgentName,
+		AgentVersion: &cfg.Version,
+		BootstrapToken: &cfg.BootstrapToken,
+		Csr: csrPEM,
+		Labels: cfg.Labels,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to register agent: %w", err)
@@ -22,5 +46,18 @@ func (s *AgentServer) Register(ctx context.Context) (string, error) {
 	if !resp.GetSuccess() {
 		return "", fmt.Errorf("register failed, remote: %s", resp.GetMessage())
 	}
+
+	if csrPEM != nil {
+		if len(resp.GetCertificate()) == 0 {
+			return "", fmt.Errorf("register failed, remote did not issue a certificate")
+		}
+		if err := WriteKeyPair(AGENT_CERT, AGENT_KEY, resp.GetCertificate(), keyPEM); err != nil {
+			return "", err
+		}
+		log.Info("Agent certificate issued by central")
+		if err := s.reconnectCentral(); err != nil {
+			log.Debug("Failed to close previous central connection: ", err)
+		}
+	}
 	return resp.GetId(), nil
 }
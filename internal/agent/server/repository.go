@@ -0,0 +1,57 @@
+// Fetching of the deployments repository by the agent itself, when git_url
+// is set. Otherwise the directory is expected to be synced externally.
+package server
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/agent/deployment"
+	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
+)
+
+// Brings the deployments directory to the commit central asks for, then
+// runs fn on it. No other checkout happens until fn returns, so the files it
+// reads are the ones at that commit.
+func (s *AgentServer) withDeployments(commit string, fn func(root string) error) error {
+	s.repoMu.Lock()
+	defer s.repoMu.Unlock()
+	if err := s.syncDeployments(commit); err != nil {
+		return err
+	}
+	return fn(GetAgentConfig().DeploymentDir)
+}
+
+// Checks out the commit, the tip of the configured branch when it is empty.
+// Must be called with s.repoMu held, see withDeployments.
+func (s *AgentServer) syncDeployments(commit string) error {
+	cfg := GetAgentConfig()
+	if cfg.GitURL == "" {
+		return nil
+	}
+
+	err := SyncRepository(cfg.DeploymentDir, Remote{
+		URL:        cfg.GitURL,
+		Branch:     cfg.GitBranch,
+		SSHKeyPath: cfg.GitSSHKey,
+		Username:   cfg.GitUsername,
+		Token:      cfg.GitToken,
+	}, commit)
+	if err != nil {
+		log.Error("Failed to sync the deployments repository: ", err)
+	}
+
+	s.fetchMu.Lock()
+	s.fetchErr = err
+	s.fetchMu.Unlock()
+	return err
+}
+
+// Last fetch failure, empty if the last fetch succeeded.
+func (s *AgentServer) FetchError() string {
+	s.fetchMu.RLock()
+	defer s.fetchMu.RUnlock()
+	if s.fetchErr == nil {
+		return ""
+	}
+	return s.fetchErr.Error()
+}
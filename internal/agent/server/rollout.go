@@ -0,0 +1,85 @@
+// grpc implementation for the rollout status, history and rollback of a
+// single deployment
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	. "github.com/Coosis/go-k8s-cord/internal/agent/cluster"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+// Maps the errors of a single deployment operation to grpc codes central
+// understands.
+func deploymentError(err error) error {
+	switch {
+	case apierrors.IsNotFound(err), errors.Is(err, ErrRevisionNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrDeploymentPaused):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return err
+	}
+}
+
+func(s *AgentServer) GetRolloutStatus(
+	ctx context.Context,
+	req *pba.GetRolloutStatusRequest,
+) (*pba.GetRolloutStatusResponse, error) {
+	namespace, err := resolveNamespace(req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	rollout, err := GetRolloutStatus(ctx, s.k8sClientSet, namespace, req.GetName())
+	if err != nil {
+		return nil, deploymentError(err)
+	}
+
+	return &pba.GetRolloutStatusResponse{
+		Status: rollout,
+	}, nil
+}
+
+func(s *AgentServer) GetDeploymentHistory(
+	ctx context.Context,
+	req *pba.GetDeploymentHistoryRequest,
+) (*pba.GetDeploymentHistoryResponse, error) {
+	namespace, err := resolveNamespace(req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := GetDeploymentHistory(ctx, s.k8sClientSet, namespace, req.GetName())
+	if err != nil {
+		return nil, deploymentError(err)
+	}
+
+	return &pba.GetDeploymentHistoryResponse{
+		Revisions: revisions,
+	}, nil
+}
+
+func(s *AgentServer) RollbackDeployment(
+	ctx context.Context,
+	req *pba.RollbackDeploymentRequest,
+) (*pba.RollbackDeploymentResponse, error) {
+	namespace, err := resolveNamespace(req.GetNamespace())
+	if err != nil {
+		return nil, err
+	}
+	revision, changed, err := RollbackDeployment(ctx, s.k8sClientSet, namespace, req.GetName(), req.GetRevision())
+	if err != nil {
+		return nil, deploymentError(err)
+	}
+
+	return &pba.RollbackDeploymentResponse{
+		Revision: proto.Int64(revision),
+		Changed:  proto.Bool(changed),
+	}, nil
+}
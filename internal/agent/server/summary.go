@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/agent/cluster"
+	. "github.com/Coosis/go-k8s-cord/internal/agent/deployment"
+	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
+
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+// Refreshes the cached cluster summary every summary_interval seconds, so
+// heartbeats stay cheap regardless of the cluster size.
+func (s *AgentServer) SummaryLoop(ctx context.Context) error {
+	for {
+		s.refreshSummary(ctx)
+
+		interval := GetAgentConfig().SummaryInterval
+		if interval <= 0 {
+			interval = DEFAULT_SUMMARY_INTERVAL
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
+	}
+}
+
+func (s *AgentServer) refreshSummary(ctx context.Context) {
+	cfg := GetAgentConfig()
+	summary, err := GetClusterSummary(ctx, s.k8sClientSet)
+	if err != nil {
+		log.Error("Failed to collect cluster summary: ", err)
+		return
+	}
+	hash, err := DeploymentHash(cfg.DeploymentDir)
+	if err != nil {
+		log.Warn("Failed to get deployments hash for the summary: ", err)
+	}
+	summary.DeploymentsHash = proto.String(hash)
+	summary.AgentVersion = proto.String(cfg.Version)
+	summary.FetchError = proto.String(s.FetchError())
+	summary.Timestamp = proto.Int64(time.Now().Unix())
+
+	s.summaryMu.Lock()
+	s.summary = summary
+	s.summaryMu.Unlock()
+}
+
+// Latest collected summary, nil until the first collection succeeds.
+func (s *AgentServer) Summary() *pbc.ClusterSummary {
+	s.summaryMu.RLock()
+	defer s.summaryMu.RUnlock()
+	return s.summary
+}
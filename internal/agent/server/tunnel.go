@@ -0,0 +1,128 @@
+// Tunnel mode: instead of listening for central, the agent opens a stream
+// to central(CentralService.Connect) and serves AgentService calls over it.
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/agent/model"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+const (
+	TUNNEL_MIN_BACKOFF = time.Second
+	TUNNEL_MAX_BACKOFF = 30 * time.Second
+)
+
+// Keeps the tunnel to central open, reconnecting with backoff.
+// Blocks until ctx is cancelled.
+func (s *AgentServer) TunnelLoop(ctx context.Context) error {
+	backoff := TUNNEL_MIN_BACKOFF
+	for {
+		start := time.Now()
+		err := s.serveTunnel(ctx)
+		if ctx.Err() != nil {
+			log.Debug("Stopping tunnel...")
+			return nil
+		}
+		if time.Since(start) > TUNNEL_MAX_BACKOFF {
+			backoff = TUNNEL_MIN_BACKOFF
+		}
+		log.Warnf("Tunnel to central closed, reconnecting in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		backoff = min(backoff*2, TUNNEL_MAX_BACKOFF)
+	}
+}
+
+func (s *AgentServer) serveTunnel(ctx context.Context) error {
+	cfg := GetAgentConfig()
+	if !cfg.Registered || cfg.UUID == "" {
+		return fmt.Errorf("agent UUID is not set, please register the agent first")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	centralClient := pbc.NewCentralServiceClient(s.central())
+	stream, err := centralClient.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open tunnel: %w", err)
+	}
+	if err := stream.Send(&pbc.TunnelResponse{AgentId: proto.String(cfg.UUID)}); err != nil {
+		return fmt.Errorf("failed to identify on tunnel: %w", err)
+	}
+	log.Info("Tunnel to central established")
+
+	var sendMu sync.Mutex
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		go func() {
+			resp := s.handleTunnelRequest(ctx, req)
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			if err := stream.Send(resp); err != nil {
+				log.Error("Failed to send tunnel response: ", err)
+			}
+		}()
+	}
+}
+
+// Runs the AgentService method named in the request, the same handler the
+// grpc server would use.
+func (s *AgentServer) handleTunnelRequest(
+	ctx context.Context,
+	req *pbc.TunnelRequest,
+) *pbc.TunnelResponse {
+	resp := &pbc.TunnelResponse{CallId: proto.Uint64(req.GetCallId())}
+	fail := func(err error) *pbc.TunnelResponse {
+		st := status.Convert(err)
+		resp.Code = proto.Int32(int32(st.Code()))
+		resp.Error = proto.String(st.Message())
+		return resp
+	}
+
+	desc := pba.AgentService_ServiceDesc
+	name, ok := strings.CutPrefix(req.GetMethod(), "/"+desc.ServiceName+"/")
+	if !ok {
+		return fail(status.Errorf(codes.Unimplemented, "unknown service for method %s", req.GetMethod()))
+	}
+	for _, md := range desc.Methods {
+		if md.MethodName != name {
+			continue
+		}
+		log.Debugf("Tunnel call %d: %s", req.GetCallId(), name)
+		dec := func(in any) error {
+			return proto.Unmarshal(req.GetPayload(), in.(proto.Message))
+		}
+		out, err := md.Handler(s, ctx, dec, nil)
+		if err != nil {
+			return fail(err)
+		}
+		payload, err := proto.Marshal(out.(proto.Message))
+		if err != nil {
+			return fail(status.Errorf(codes.Internal, "failed to encode response: %v", err))
+		}
+		resp.Code = proto.Int32(int32(codes.OK))
+		resp.Payload = payload
+		return resp
+	}
+	return fail(status.Errorf(codes.Unimplemented, "unknown method %s", req.GetMethod()))
+}
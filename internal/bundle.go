@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"maps"
+	"slices"
+)
+
+/// SHA-256 over the paths and contents of bundled files, sorted by path,
+/// hex encoded. Central and agents must agree on it.
+func BundleHash(files map[string][]byte) string {
+	h := sha256.New()
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		content := files[path]
+		binary.Write(h, binary.BigEndian, uint64(len(path)))
+		h.Write([]byte(path))
+		binary.Write(h, binary.BigEndian, uint64(len(content)))
+		h.Write(content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -2,12 +2,16 @@
 package deployment
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path"
+	"path/filepath"
 	"slices"
 
 	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -34,6 +38,19 @@ func DeploymentsHash(repo *gogit.Repository) (string, error) {
 	return head.Hash().String(), nil
 }
 
+// Commit a branch, tag or (abbreviated) commit hash points to, HEAD when
+// ref is empty.
+func ResolveRef(repo *gogit.Repository, ref string) (string, error) {
+	if ref == "" {
+		return DeploymentsHash(repo)
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve %s: %v", ref, err)
+	}
+	return hash.String(), nil
+}
+
 func AddFile(repo *gogit.Repository, filePath string) error {
 	w, err := repo.Worktree()
 	if err != nil {
@@ -121,3 +138,70 @@ func ListDeploymentFiles(repo *gogit.Repository) ([]string, error) {
 
 	return files, nil
 }
+
+// Blob hash of a file at the given commit, empty when the file does not
+// exist there.
+func FileHashAt(repo *gogit.Repository, commitHash string, filePath string) (string, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitHash))
+	if err != nil {
+		return "", fmt.Errorf("Failed to get commit %s: %v", commitHash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get tree of %s: %v", commitHash, err)
+	}
+	file, err := tree.File(filePath)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to get %s at %s: %v", filePath, commitHash, err)
+	}
+	return file.Hash.String(), nil
+}
+
+// Contents of the files at the given commit, keyed by path. Directories are
+// expanded to every file below them, "." is the whole repository.
+func FilesAt(repo *gogit.Repository, commitHash string, paths []string) (map[string][]byte, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(commitHash))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get commit %s: %v", commitHash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get tree of %s: %v", commitHash, err)
+	}
+
+	files := map[string][]byte{}
+	for _, p := range paths {
+		p = path.Clean(filepath.ToSlash(p))
+		dir := tree
+		if p != "." {
+			file, err := tree.File(p)
+			if err == nil {
+				content, err := file.Contents()
+				if err != nil {
+					return nil, fmt.Errorf("Failed to read %s: %v", p, err)
+				}
+				files[p] = []byte(content)
+				continue
+			}
+			dir, err = tree.Tree(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s does not exist at %s", p, commitHash)
+			}
+		}
+		err := dir.Files().ForEach(func(f *object.File) error {
+			content, err := f.Contents()
+			if err != nil {
+				return fmt.Errorf("Failed to read %s: %v", f.Name, err)
+			}
+			files[path.Join(p, f.Name)] = []byte(content)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -2,9 +2,49 @@ package model
 
 import "google.golang.org/grpc"
 
+const (
+	// etcd key prefixes
+	AGENTS_PREFIX   = "/cord/agents/"
+	ALIVE_PREFIX    = "/cord/alive/"
+	TOKENS_PREFIX   = "/cord/tokens/"
+	DESIRED_PREFIX  = "/cord/desired/"
+	ROLLOUTS_PREFIX = "/cord/rollouts/"
+
+	// how central reaches an agent
+	CONNECTION_MODE_DIAL   = "dial"
+	CONNECTION_MODE_TUNNEL = "tunnel"
+)
+
+// Registry record of an agent, persisted in etcd under AGENTS_PREFIX.
 type AgentMetadata struct {
-	Name string
-	GrpcEndpoint string
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	GrpcEndpoint    string            `json:"grpc_endpoint"`
+	// Unix timestamp of the registration
+	RegisteredAt    int64             `json:"registered_at"`
+	Version         string            `json:"version"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	// SHA-256 of the client certificate presented at registration
+	CertFingerprint string            `json:"cert_fingerprint"`
+	ConnectionMode  string            `json:"connection_mode"`
+	// Files the agent re-applies when they drift
+	SelfHeal        []string          `json:"self_heal,omitempty"`
+	// Paths the agent reconciles to, nil when reconciliation is off
+	Reconcile       *ReconcileSpec    `json:"reconcile,omitempty"`
+	// Branch, tag or commit of the deployments repository the agent
+	// follows, central's HEAD when empty
+	TargetRef       string            `json:"target_ref,omitempty"`
 
-	AgentConn *grpc.ClientConn
+	// Tracked through the liveness lease, never persisted
+	Online   bool `json:"-"`
+	// Whether the agent's tunnel is currently connected, never persisted
+	Tunneled bool `json:"-"`
+	// Latest summary from the heartbeats, never persisted
+	Summary  *ClusterSummary `json:"-"`
+	// Latest drift report, never persisted
+	Drift    *DriftReport `json:"-"`
+	// Latest reconcile report, never persisted
+	ReconcileStatus *ReconcileStatus `json:"-"`
+	// Established lazily, never persisted
+	AgentConn *grpc.ClientConn `json:"-"`
 }
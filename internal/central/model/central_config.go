@@ -10,14 +10,17 @@ import (
 )
 
 const (
-	CENTRAL_CONFIG         = "central_config.yaml"
-	DEFAULT_ALIVE_INTERVAL = 5 // seconds
-	DEFAULT_ETCD_ADDR      = "localhost:2379"
-	DEFAULT_HTTPS_PORT     = ":10201"
-	DEFAULT_GRPC_PORT      = ":10202"
-	HTTPS_LOCALHOST        = "https://localhost" + DEFAULT_HTTPS_PORT
-	GRPC_LOCALHOST         = "https://localhost" + DEFAULT_GRPC_PORT
-	LOCAL_STATUS_URL       = HTTPS_LOCALHOST + "/status"
+	CENTRAL_CONFIG            = "central_config.yaml"
+	DEFAULT_ALIVE_INTERVAL    = 5 // seconds
+	DEFAULT_ETCD_ADDR         = "localhost:2379"
+	DEFAULT_HTTPS_PORT        = ":10201"
+	DEFAULT_GRPC_PORT         = ":10202"
+	DEFAULT_AGENT_CERT_TTL    = 24 // hours
+	DEFAULT_FLEET_CONCURRENCY = 10
+	DEFAULT_FLEET_TIMEOUT     = 30 // seconds
+	HTTPS_LOCALHOST           = "https://localhost" + DEFAULT_HTTPS_PORT
+	GRPC_LOCALHOST            = "https://localhost" + DEFAULT_GRPC_PORT
+	LOCAL_STATUS_URL          = HTTPS_LOCALHOST + "/status"
 
 	// git related constants
 	DEFAULT_DEPLOYMENTS_DIR = "deployments"
@@ -51,10 +54,19 @@ type CentralConfig struct {
 	HTTPSPort     string `yaml:"https_port"`
 	GRPCPort      string `yaml:"grpc_port"`
 	EtcdAddr      string `yaml:"etcd_addr"`
+	// Lifetime in hours of the certificates issued to agents
+	AgentCertTTL  int    `yaml:"agent_cert_ttl"`
+	// Agents called at the same time by the fleet endpoints
+	FleetConcurrency int `yaml:"fleet_concurrency"`
+	// Seconds each agent has to answer a fleet call
+	FleetTimeout     int `yaml:"fleet_timeout"`
 
 	DeploymentsDir string `yaml:"deployments_dir"`
 	GitRemoteName  string `yaml:"git_remote_name"`
 	GitBranch      string `yaml:"git_branch"`
+	// Ship the applied files to agents instead of relying on their own copy
+	// of the repository
+	ShipBundles    bool   `yaml:"ship_bundles"`
 }
 
 func NewCentralConfig() *CentralConfig {
@@ -64,10 +76,14 @@ func NewCentralConfig() *CentralConfig {
 		HTTPSPort:     viper.GetString("https_port"),
 		GRPCPort:      viper.GetString("grpc_port"),
 		EtcdAddr:      viper.GetString("etcd_addr"),
+		AgentCertTTL:  viper.GetInt("agent_cert_ttl"),
+		FleetConcurrency: viper.GetInt("fleet_concurrency"),
+		FleetTimeout:     viper.GetInt("fleet_timeout"),
 
 		DeploymentsDir: viper.GetString("deployments_dir"),
 		GitRemoteName:  viper.GetString("git_remote_name"),
 		GitBranch:      viper.GetString("git_branch"),
+		ShipBundles:    viper.GetBool("ship_bundles"),
 	}
 }
 
@@ -83,10 +99,14 @@ func CentralServerConfigSetup() error {
 	viper.SetDefault("https_port", DEFAULT_HTTPS_PORT)
 	viper.SetDefault("grpc_port", DEFAULT_GRPC_PORT)
 	viper.SetDefault("etcd_addr", DEFAULT_ETCD_ADDR)
+	viper.SetDefault("agent_cert_ttl", DEFAULT_AGENT_CERT_TTL)
+	viper.SetDefault("fleet_concurrency", DEFAULT_FLEET_CONCURRENCY)
+	viper.SetDefault("fleet_timeout", DEFAULT_FLEET_TIMEOUT)
 
 	viper.SetDefault("deployments_dir", DEFAULT_DEPLOYMENTS_DIR)
 	viper.SetDefault("git_remote_name", DEFAULT_GIT_REMOTE)
 	viper.SetDefault("git_branch", DEFAULT_GIT_BRANCH)
+	viper.SetDefault("ship_bundles", false)
 
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		log.Info("Config file changed: ", e.Name)
@@ -0,0 +1,32 @@
+package model
+
+const (
+	// Status of a deployment file on an agent, see the files x agents matrix
+	// the file is recorded, unchanged in the repository and reported by the agent
+	FILE_STATUS_APPLIED  = "applied"
+	// the file is recorded but the agent did not report it yet
+	FILE_STATUS_PENDING  = "pending"
+	// the file changed in the repository since it was applied
+	FILE_STATUS_OUTDATED = "outdated"
+	// the file was applied but is gone from the repository
+	FILE_STATUS_MISSING  = "missing"
+)
+
+// Files central applied on an agent, persisted in etcd under DESIRED_PREFIX.
+type DesiredState struct {
+	// keyed by path, relative to the deployments repository
+	Files map[string]DesiredFile `json:"files"`
+}
+
+type DesiredFile struct {
+	// deployments repository HEAD on the agent when the file was applied
+	Commit    string   `json:"commit"`
+	Namespace string   `json:"namespace,omitempty"`
+	// hash of the bundle the file was shipped in, empty if the agent read it
+	// from its own deployments repository
+	Bundle    string   `json:"bundle,omitempty"`
+	// kind/namespace/name of the applied objects
+	Objects   []string `json:"objects"`
+	// Unix timestamp of the apply
+	AppliedAt int64    `json:"applied_at"`
+}
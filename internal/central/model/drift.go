@@ -0,0 +1,32 @@
+package model
+
+// Latest drift report of an agent, objects whose live state differs from
+// the files they were applied from.
+type DriftReport struct {
+	// Files applied on the agent that were compared
+	CheckedFiles []string        `json:"checked_files"`
+	Objects      []DriftedObject `json:"objects"`
+	// Unix timestamp of the comparison
+	Timestamp    int64           `json:"timestamp"`
+}
+
+type DriftedObject struct {
+	File       string `json:"file"`
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name,omitempty"`
+	// unified diff from the live object to the repository version
+	Diff       string `json:"diff,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Whether the file has drifted objects, used by the templates
+func (d *DriftReport) Drifted(file string) bool {
+	for _, obj := range d.Objects {
+		if obj.File == file {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package model
+
+const (
+	// Outcome of the last reconcile on an agent
+	RECONCILE_STATUS_SYNCED = "synced"
+	RECONCILE_STATUS_FAILED = "failed"
+)
+
+// Repository paths an agent keeps its cluster reconciled to, persisted with
+// the agent. Agents without one are only applied to on request.
+type ReconcileSpec struct {
+	// files and directories, relative to the deployments repository
+	Paths     []string `json:"paths"`
+	// namespace for objects without one, empty for the agent default
+	Namespace string   `json:"namespace,omitempty"`
+}
+
+// Latest reconcile reported by an agent.
+type ReconcileStatus struct {
+	Commit      string `json:"commit"`
+	Status      string `json:"status"`
+	// kept after the agent recovers
+	LastError   string `json:"last_error,omitempty"`
+	// Unix timestamp of the last failure
+	LastErrorAt int64  `json:"last_error_at,omitempty"`
+	Applied     int32  `json:"applied"`
+	Unchanged   int32  `json:"unchanged"`
+	Pruned      int32  `json:"pruned"`
+	Failed      int32  `json:"failed"`
+	// Unix timestamp of the reconcile
+	Timestamp   int64  `json:"timestamp"`
+}
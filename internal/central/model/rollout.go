@@ -0,0 +1,82 @@
+package model
+
+const (
+	// Status of a rollout
+	ROLLOUT_STATUS_RUNNING     = "running"
+	ROLLOUT_STATUS_SUCCEEDED   = "succeeded"
+	// a wave failed and the rollout stopped where it was
+	ROLLOUT_STATUS_HALTED      = "halted"
+	// a wave failed and every agent of the rollout was rolled back
+	ROLLOUT_STATUS_ROLLED_BACK = "rolled_back"
+	// a wave failed and so did the rollback of some agents
+	ROLLOUT_STATUS_FAILED      = "failed"
+	ROLLOUT_STATUS_ABORTED     = "aborted"
+
+	// Status of an agent in a rollout
+	ROLLOUT_AGENT_PENDING     = "pending"
+	// applied, waiting for the deployments to become healthy
+	ROLLOUT_AGENT_PROGRESSING = "progressing"
+	ROLLOUT_AGENT_HEALTHY     = "healthy"
+	ROLLOUT_AGENT_FAILED      = "failed"
+	ROLLOUT_AGENT_ROLLED_BACK = "rolled_back"
+
+	// What a rollout does when a wave fails
+	ROLLOUT_ON_FAILURE_HALT     = "halt"
+	ROLLOUT_ON_FAILURE_ROLLBACK = "rollback"
+
+	// seconds a wave has to become healthy when the spec does not say
+	DEFAULT_ROLLOUT_HEALTH_TIMEOUT = 300
+)
+
+// Waves used when the spec does not have any: a canary agent, a quarter of
+// the agents, then the rest.
+var DEFAULT_ROLLOUT_WAVES = []string{"1", "25%"}
+
+// What to roll out and where.
+type RolloutSpec struct {
+	// files and directories, relative to the deployments repository
+	Files         []string `json:"files"`
+	// namespace for objects without one, empty for the agent default
+	Namespace     string   `json:"namespace,omitempty"`
+	// agent IDs, the first waves take the first agents
+	Agents        []string `json:"agents,omitempty"`
+	// label selector over the agent labels, used when Agents is empty
+	Selector      string   `json:"selector,omitempty"`
+	// agents per wave, a count or a percentage of all targeted agents,
+	// agents left after the last wave make up one more wave
+	Waves         []string `json:"waves,omitempty"`
+	// ROLLOUT_ON_FAILURE_*, halt when empty
+	OnFailure     string   `json:"on_failure,omitempty"`
+	// seconds each wave has to become healthy
+	HealthTimeout int64    `json:"health_timeout,omitempty"`
+}
+
+// A rollout and its progress, persisted in etcd under ROLLOUTS_PREFIX.
+type Rollout struct {
+	ID        string                   `json:"id"`
+	Spec      RolloutSpec              `json:"spec"`
+	// HEAD of the deployments repository when the rollout was created,
+	// every wave applies the files at this commit
+	Commit    string                   `json:"commit"`
+	// agent IDs of each wave
+	Waves     [][]string               `json:"waves"`
+	// index of the running wave
+	Wave      int                      `json:"wave"`
+	Status    string                   `json:"status"`
+	Error     string                   `json:"error,omitempty"`
+	Agents    map[string]*RolloutAgent `json:"agents"`
+	// Unix timestamps
+	CreatedAt int64                    `json:"created_at"`
+	UpdatedAt int64                    `json:"updated_at"`
+}
+
+type RolloutAgent struct {
+	Wave        int                    `json:"wave"`
+	Status      string                 `json:"status"`
+	Error       string                 `json:"error,omitempty"`
+	// recorded files the rollout overwrote, rollbacks apply them again.
+	// nil until taken, right before the first apply
+	Previous    map[string]DesiredFile `json:"previous"`
+	// file -> namespace/name of the deployments applied from it
+	Deployments map[string][]string    `json:"deployments,omitempty"`
+}
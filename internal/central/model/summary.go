@@ -0,0 +1,34 @@
+package model
+
+import "fmt"
+
+// Latest cluster summary reported by an agent in its heartbeats.
+type ClusterSummary struct {
+	KubernetesVersion string           `json:"kubernetes_version"`
+	NodeCount         int32            `json:"node_count"`
+	ReadyNodeCount    int32            `json:"ready_node_count"`
+	// millicores
+	CPUCapacity       int64            `json:"cpu_capacity"`
+	CPUAllocatable    int64            `json:"cpu_allocatable"`
+	// bytes
+	MemoryCapacity    int64            `json:"memory_capacity"`
+	MemoryAllocatable int64            `json:"memory_allocatable"`
+	PodsByPhase       map[string]int32 `json:"pods_by_phase"`
+	DeploymentsHash   string           `json:"deployments_hash"`
+	AgentVersion      string           `json:"agent_version"`
+	// Unix timestamp of collection
+	Timestamp         int64            `json:"timestamp"`
+	// set when the agent failed to fetch the deployments repository
+	FetchError        string           `json:"fetch_error,omitempty"`
+}
+
+// "allocatable/capacity cores", used by the templates
+func (c *ClusterSummary) CPU() string {
+	return fmt.Sprintf("%.1f/%.1f cores", float64(c.CPUAllocatable)/1000, float64(c.CPUCapacity)/1000)
+}
+
+// "allocatable/capacity GiB", used by the templates
+func (c *ClusterSummary) Memory() string {
+	const gib = 1 << 30
+	return fmt.Sprintf("%.1f/%.1f GiB", float64(c.MemoryAllocatable)/gib, float64(c.MemoryCapacity)/gib)
+}
@@ -0,0 +1,87 @@
+// Desired state of the agents: which deployment files were applied on them,
+// at which commit.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+)
+
+func (r *Registry) Desired(ctx context.Context, agentID string) (DesiredState, error) {
+	state, _, err := r.getDesired(ctx, agentID)
+	return state, err
+}
+
+// Desired state of every agent, keyed by agent ID.
+func (r *Registry) AllDesired(ctx context.Context) (map[string]DesiredState, error) {
+	resp, err := r.etcd.Get(ctx, DESIRED_PREFIX, clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load desired state: %w", err)
+	}
+	states := map[string]DesiredState{}
+	for _, kv := range resp.Kvs {
+		state := DesiredState{}
+		if err := json.Unmarshal(kv.Value, &state); err != nil {
+			return nil, fmt.Errorf("failed to decode desired state %s: %w", kv.Key, err)
+		}
+		states[strings.TrimPrefix(string(kv.Key), DESIRED_PREFIX)] = state
+	}
+	return states, nil
+}
+
+// Read-modify-write of the desired state of an agent, retried when the key
+// changed in the meantime.
+func (r *Registry) UpdateDesired(
+	ctx context.Context,
+	agentID string,
+	fn func(state *DesiredState),
+) error {
+	key := DESIRED_PREFIX + agentID
+	for {
+		state, revision, err := r.getDesired(ctx, agentID)
+		if err != nil {
+			return err
+		}
+		fn(&state)
+		value, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("failed to encode desired state of %s: %w", agentID, err)
+		}
+
+		resp, err := r.etcd.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+			Then(clientv3.OpPut(key, string(value))).
+			Commit()
+		if err != nil {
+			return fmt.Errorf("failed to save desired state of %s: %w", agentID, err)
+		}
+		if resp.Succeeded {
+			return nil
+		}
+	}
+}
+
+// Returns the state along with the mod revision of its key, 0 if missing.
+func (r *Registry) getDesired(ctx context.Context, agentID string) (DesiredState, int64, error) {
+	state := DesiredState{Files: map[string]DesiredFile{}}
+	resp, err := r.etcd.Get(ctx, DESIRED_PREFIX+agentID)
+	if err != nil {
+		return state, 0, fmt.Errorf("failed to load desired state of %s: %w", agentID, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return state, 0, nil
+	}
+	if err := json.Unmarshal(resp.Kvs[0].Value, &state); err != nil {
+		return state, 0, fmt.Errorf("failed to decode desired state of %s: %w", agentID, err)
+	}
+	if state.Files == nil {
+		state.Files = map[string]DesiredFile{}
+	}
+	return state, resp.Kvs[0].ModRevision, nil
+}
@@ -0,0 +1,129 @@
+// Agent liveness driven by etcd leases: every heartbeat refreshes a lease
+// attached to the agent's key under ALIVE_PREFIX, and the key disappears
+// once the lease expires.
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+)
+
+const (
+	LIVENESS_MIN_BACKOFF = time.Second
+	LIVENESS_MAX_BACKOFF = 30 * time.Second
+)
+
+// Refreshes the liveness lease of an agent, granting a new one with the
+// given TTL(in seconds) if there is none yet or the old one has expired.
+func (r *Registry) Heartbeat(ctx context.Context, agentID string, ttl int64) error {
+	r.mu.RLock()
+	lease, ok := r.leases[agentID]
+	r.mu.RUnlock()
+
+	if ok {
+		_, err := r.etcd.KeepAliveOnce(ctx, lease)
+		if err == nil {
+			return nil
+		}
+		log.Debugf("Failed to refresh lease for agent %s, granting a new one: %v", agentID, err)
+	}
+
+	grant, err := r.etcd.Grant(ctx, max(ttl, 1))
+	if err != nil {
+		return fmt.Errorf("failed to grant lease for agent %s: %w", agentID, err)
+	}
+	now := fmt.Sprintf("%d", time.Now().Unix())
+	_, err = r.etcd.Put(ctx, ALIVE_PREFIX+agentID, now, clientv3.WithLease(grant.ID))
+	if err != nil {
+		return fmt.Errorf("failed to register heartbeat in etcd: %w", err)
+	}
+
+	r.mu.Lock()
+	r.leases[agentID] = grant.ID
+	r.mu.Unlock()
+	return nil
+}
+
+// Watches ALIVE_PREFIX and keeps the Online flag of every agent up to date,
+// logging online/offline transitions. Retries with backoff while etcd is
+// unreachable, agents keep their last known state meanwhile. Blocks until
+// ctx is cancelled.
+func (r *Registry) WatchLiveness(ctx context.Context) error {
+	backoff := LIVENESS_MIN_BACKOFF
+	for {
+		resp, err := r.etcd.Get(ctx, ALIVE_PREFIX, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Warnf("Failed to get agent liveness, retrying in %s: %v", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil
+			}
+			backoff = min(backoff*2, LIVENESS_MAX_BACKOFF)
+			continue
+		}
+		backoff = LIVENESS_MIN_BACKOFF
+		alive := make(map[string]bool)
+		for _, kv := range resp.Kvs {
+			alive[strings.TrimPrefix(string(kv.Key), ALIVE_PREFIX)] = true
+		}
+		r.mu.Lock()
+		for id, agent := range r.agents {
+			r.setOnline(id, agent, alive[id])
+		}
+		r.mu.Unlock()
+
+		wch := r.etcd.Watch(
+			ctx,
+			ALIVE_PREFIX,
+			clientv3.WithPrefix(),
+			clientv3.WithRev(resp.Header.Revision+1),
+		)
+		for wresp := range wch {
+			if err := wresp.Err(); err != nil {
+				log.Warn("Liveness watch interrupted: ", err)
+				break
+			}
+			for _, ev := range wresp.Events {
+				id := strings.TrimPrefix(string(ev.Kv.Key), ALIVE_PREFIX)
+				r.mu.Lock()
+				if agent, ok := r.agents[id]; ok {
+					r.setOnline(id, agent, ev.Type == clientv3.EventTypePut)
+				}
+				if ev.Type == clientv3.EventTypeDelete {
+					delete(r.leases, id)
+				}
+				r.mu.Unlock()
+			}
+		}
+
+		if ctx.Err() != nil {
+			log.Debug("Stopping liveness watch...")
+			return nil
+		}
+		log.Debug("Liveness watch closed, restarting...")
+	}
+}
+
+// must be called with r.mu held
+func (r *Registry) setOnline(agentID string, agent *AgentMetadata, online bool) {
+	if agent.Online == online {
+		return
+	}
+	agent.Online = online
+	if online {
+		log.Infof("Agent %s(%s) is online", agent.Name, agentID)
+	} else {
+		log.Infof("Agent %s(%s) is offline", agent.Name, agentID)
+	}
+}
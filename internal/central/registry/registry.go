@@ -0,0 +1,323 @@
+// Agent registry shared by the grpc and http handlers of central.
+// Records are persisted in etcd, connections to agents are kept in memory.
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	log "github.com/sirupsen/logrus"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/apimachinery/pkg/labels"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+)
+
+var ErrAgentNotFound = errors.New("agent not found")
+
+type Registry struct {
+	mu     sync.RWMutex
+	agents map[string]*AgentMetadata
+	// liveness leases, see liveness.go
+	leases map[string]clientv3.LeaseID
+	// connected agent tunnels, see tunnel.go
+	tunnels map[string]*Tunnel
+
+	etcd      *clientv3.Client
+	tlsConfig *tls.Config
+}
+
+func NewRegistry(etcd *clientv3.Client, tlsConfig *tls.Config) *Registry {
+	return &Registry{
+		agents:    make(map[string]*AgentMetadata),
+		leases:    make(map[string]clientv3.LeaseID),
+		tunnels:   make(map[string]*Tunnel),
+		etcd:      etcd,
+		tlsConfig: tlsConfig,
+	}
+}
+
+// Loads every agent record stored under AGENTS_PREFIX.
+// Connections are not established here, see Conn.
+func (r *Registry) Load(ctx context.Context) error {
+	resp, err := r.etcd.Get(ctx, AGENTS_PREFIX, clientv3.WithPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to load agents from etcd: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, kv := range resp.Kvs {
+		agent := &AgentMetadata{}
+		if err := json.Unmarshal(kv.Value, agent); err != nil {
+			log.Errorf("Failed to decode agent record %s: %v", kv.Key, err)
+			continue
+		}
+		if agent.ID == "" {
+			agent.ID = strings.TrimPrefix(string(kv.Key), AGENTS_PREFIX)
+		}
+		r.agents[agent.ID] = agent
+	}
+	log.Infof("Loaded %d agents from etcd", len(r.agents))
+	return nil
+}
+
+// Returns a copy of the agent record, safe to use without holding any lock.
+func (r *Registry) Get(agentID string) (AgentMetadata, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	agent, ok := r.agents[agentID]
+	if !ok {
+		return AgentMetadata{}, false
+	}
+	return r.snapshot(agent), true
+}
+
+// Returns copies of all agent records, ordered by ID.
+func (r *Registry) List() []AgentMetadata {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	agents := make([]AgentMetadata, 0, len(r.agents))
+	for _, id := range slices.Sorted(maps.Keys(r.agents)) {
+		agents = append(agents, r.snapshot(r.agents[id]))
+	}
+	return agents
+}
+
+// Returns copies of the agent records whose labels match the selector,
+// ordered by ID.
+func (r *Registry) Select(selector labels.Selector) []AgentMetadata {
+	agents := r.List()
+	return slices.DeleteFunc(agents, func(agent AgentMetadata) bool {
+		return !selector.Matches(labels.Set(agent.Labels))
+	})
+}
+
+// Applies fn to the agent record, creating it first if it does not exist
+// and create is set. The record is written back to etcd only if it changed.
+// Changing the grpc endpoint drops the current connection.
+func (r *Registry) Update(
+	ctx context.Context,
+	agentID string,
+	create bool,
+	fn func(agent *AgentMetadata),
+) (AgentMetadata, error) {
+	r.mu.Lock()
+	agent, exists := r.agents[agentID]
+	if !exists {
+		if !create {
+			r.mu.Unlock()
+			return AgentMetadata{}, fmt.Errorf("agent %s: %w", agentID, ErrAgentNotFound)
+		}
+		agent = &AgentMetadata{ID: agentID}
+	}
+	before, _ := json.Marshal(agent)
+	oldEndpoint := agent.GrpcEndpoint
+	fn(agent)
+	agent.ID = agentID
+	after, err := json.Marshal(agent)
+	if err != nil {
+		r.mu.Unlock()
+		return AgentMetadata{}, fmt.Errorf("failed to encode agent %s: %w", agentID, err)
+	}
+
+	var stale *grpc.ClientConn
+	if agent.GrpcEndpoint != oldEndpoint && agent.AgentConn != nil {
+		// the next call dials the new endpoint
+		log.Debugf("Agent %s gRPC endpoint changed, dropping old connection", agentID)
+		stale = agent.AgentConn
+		agent.AgentConn = nil
+	}
+	r.agents[agentID] = agent
+	result := r.snapshot(agent)
+	r.mu.Unlock()
+
+	if stale != nil {
+		stale.Close()
+	}
+	if exists && string(before) == string(after) {
+		return result, nil
+	}
+	log.Debugf("Agent %s metadata changed, saving to registry", agentID)
+	if _, err := r.etcd.Put(ctx, AGENTS_PREFIX+agentID, string(after)); err != nil {
+		return result, fmt.Errorf("failed to save agent %s: %w", agentID, err)
+	}
+	return result, nil
+}
+
+// Forgets an agent: closes its connection, revokes its liveness lease and
+// deletes its keys from etcd.
+func (r *Registry) Remove(ctx context.Context, agentID string) error {
+	r.mu.Lock()
+	agent, ok := r.agents[agentID]
+	lease, hasLease := r.leases[agentID]
+	tunnel := r.tunnels[agentID]
+	delete(r.agents, agentID)
+	delete(r.leases, agentID)
+	delete(r.tunnels, agentID)
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("agent %s: %w", agentID, ErrAgentNotFound)
+	}
+
+	if tunnel != nil {
+		tunnel.Close()
+	}
+
+	if agent.AgentConn != nil {
+		log.Debugf("Closing gRPC connection for agent %s", agentID)
+		if err := agent.AgentConn.Close(); err != nil {
+			log.Error("Failed to close gRPC connection for agent ", agentID, ": ", err)
+		}
+	}
+	if hasLease {
+		if _, err := r.etcd.Revoke(ctx, lease); err != nil {
+			log.Debugf("Failed to revoke lease for agent %s: %v", agentID, err)
+		}
+	}
+	_, err := r.etcd.Txn(ctx).Then(
+		clientv3.OpDelete(AGENTS_PREFIX+agentID),
+		clientv3.OpDelete(ALIVE_PREFIX+agentID),
+		clientv3.OpDelete(DESIRED_PREFIX+agentID),
+	).Commit()
+	if err != nil {
+		return fmt.Errorf("failed to delete agent %s from etcd: %w", agentID, err)
+	}
+	log.Infof("Agent %s(%s) removed from registry", agent.Name, agentID)
+	return nil
+}
+
+// Returns the connection to an agent: its tunnel if connected, otherwise a
+// grpc connection to its endpoint, created on first use.
+func (r *Registry) Conn(agentID string) (grpc.ClientConnInterface, error) {
+	r.mu.RLock()
+	agent, ok := r.agents[agentID]
+	if tunnel := r.tunnels[agentID]; ok && tunnel != nil {
+		r.mu.RUnlock()
+		return tunnel, nil
+	}
+	if ok && agent.AgentConn != nil {
+		conn := agent.AgentConn
+		r.mu.RUnlock()
+		return conn, nil
+	}
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("agent %s: %w", agentID, ErrAgentNotFound)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok = r.agents[agentID]
+	if !ok {
+		return nil, fmt.Errorf("agent %s: %w", agentID, ErrAgentNotFound)
+	}
+	// someone else may have dialed or connected in the meantime
+	if tunnel := r.tunnels[agentID]; tunnel != nil {
+		return tunnel, nil
+	}
+	if agent.AgentConn != nil {
+		return agent.AgentConn, nil
+	}
+	if agent.ConnectionMode == CONNECTION_MODE_TUNNEL {
+		return nil, fmt.Errorf("agent %s connects through a tunnel, which is currently down", agentID)
+	}
+	log.Debugf("No connection to agent %s yet, dialing %s", agentID, agent.GrpcEndpoint)
+	cred := credentials.NewTLS(r.tlsConfig)
+	conn, err := grpc.NewClient(agent.GrpcEndpoint, grpc.WithTransportCredentials(cred))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC connection to agent: %w", err)
+	}
+	agent.AgentConn = conn
+	return conn, nil
+}
+
+// Routes calls to the agent through the tunnel, replacing any previous one.
+func (r *Registry) AttachTunnel(agentID string, tunnel *Tunnel) error {
+	r.mu.Lock()
+	if _, ok := r.agents[agentID]; !ok {
+		r.mu.Unlock()
+		return fmt.Errorf("agent %s: %w", agentID, ErrAgentNotFound)
+	}
+	old := r.tunnels[agentID]
+	r.tunnels[agentID] = tunnel
+	r.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	log.Infof("Tunnel to agent %s connected", agentID)
+	return nil
+}
+
+// Forgets the tunnel unless it has been replaced in the meantime.
+func (r *Registry) DetachTunnel(agentID string, tunnel *Tunnel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tunnels[agentID] == tunnel {
+		delete(r.tunnels, agentID)
+		log.Infof("Tunnel to agent %s disconnected", agentID)
+	}
+}
+
+// Closes every open agent connection, used on shutdown.
+func (r *Registry) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, tunnel := range r.tunnels {
+		tunnel.Close()
+	}
+	for id, agent := range r.agents {
+		if agent.AgentConn != nil {
+			log.Debugf("Closing gRPC connection for agent %s", id)
+			if err := agent.AgentConn.Close(); err != nil {
+				log.Error("Failed to close gRPC connection for agent ", id, ": ", err)
+			} else {
+				log.Debugf("gRPC connection for agent %s closed successfully", id)
+			}
+			agent.AgentConn = nil
+		} else {
+			log.Debugf("No gRPC connection to close for agent %s", id)
+		}
+	}
+}
+
+// must be called with r.mu held
+func (r *Registry) snapshot(agent *AgentMetadata) AgentMetadata {
+	cp := *agent
+	cp.Labels = maps.Clone(agent.Labels)
+	cp.SelfHeal = slices.Clone(agent.SelfHeal)
+	cp.AgentConn = nil
+	cp.Tunneled = r.tunnels[agent.ID] != nil
+	if agent.Summary != nil {
+		summary := *agent.Summary
+		summary.PodsByPhase = maps.Clone(agent.Summary.PodsByPhase)
+		cp.Summary = &summary
+	}
+	if agent.Drift != nil {
+		drift := *agent.Drift
+		drift.CheckedFiles = slices.Clone(agent.Drift.CheckedFiles)
+		drift.Objects = slices.Clone(agent.Drift.Objects)
+		cp.Drift = &drift
+	}
+	if agent.Reconcile != nil {
+		spec := *agent.Reconcile
+		spec.Paths = slices.Clone(agent.Reconcile.Paths)
+		cp.Reconcile = &spec
+	}
+	if agent.ReconcileStatus != nil {
+		status := *agent.ReconcileStatus
+		cp.ReconcileStatus = &status
+	}
+	return cp
+}
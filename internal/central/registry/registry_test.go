@@ -0,0 +1,389 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+)
+
+// In-memory stand-in for the parts of etcd the registry uses. Leases are
+// not bound to keys, expireAll drops every lease and liveness key at once.
+type fakeEtcd struct {
+	clientv3.KV
+	clientv3.Lease
+	clientv3.Watcher
+
+	mu        sync.Mutex
+	rev       int64
+	kvs       map[string]string
+	history   []*clientv3.Event
+	leases    map[clientv3.LeaseID]bool
+	nextLease clientv3.LeaseID
+	watchers  []*fakeWatch
+	// number of the next Gets that fail, as with etcd unreachable
+	failGets  int
+}
+
+type fakeWatch struct {
+	key    string
+	prefix bool
+	ch     chan clientv3.WatchResponse
+	ctx    context.Context
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		kvs:    make(map[string]string),
+		leases: make(map[clientv3.LeaseID]bool),
+	}
+}
+
+func (f *fakeEtcd) client(ctx context.Context) *clientv3.Client {
+	client := clientv3.NewCtxClient(ctx)
+	client.KV = f
+	client.Lease = f
+	client.Watcher = f
+	return client
+}
+
+func (w *fakeWatch) matches(key string) bool {
+	if w.prefix {
+		return strings.HasPrefix(key, w.key)
+	}
+	return key == w.key
+}
+
+// must be called with f.mu held
+func (f *fakeEtcd) emit(typ mvccpb.Event_EventType, key string, value string) {
+	f.rev++
+	ev := &clientv3.Event{
+		Type: typ,
+		Kv:   &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: f.rev},
+	}
+	f.history = append(f.history, ev)
+	for _, w := range f.watchers {
+		if !w.matches(key) {
+			continue
+		}
+		select {
+		case w.ch <- clientv3.WatchResponse{Header: pb.ResponseHeader{Revision: f.rev}, Events: []*clientv3.Event{ev}}:
+		case <-w.ctx.Done():
+		}
+	}
+}
+
+func (f *fakeEtcd) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: f.rev}
+}
+
+func (f *fakeEtcd) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failGets > 0 {
+		f.failGets--
+		return nil, errors.New("etcdserver: request timed out")
+	}
+	resp := &clientv3.GetResponse{Header: f.header()}
+	for k, v := range f.kvs {
+		if k != key && !(op.IsOptsWithPrefix() && strings.HasPrefix(k, key)) {
+			continue
+		}
+		kv := &mvccpb.KeyValue{Key: []byte(k)}
+		if !op.IsKeysOnly() {
+			kv.Value = []byte(v)
+		}
+		resp.Kvs = append(resp.Kvs, kv)
+	}
+	resp.Count = int64(len(resp.Kvs))
+	return resp, nil
+}
+
+func (f *fakeEtcd) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.kvs[key] = val
+	f.emit(mvccpb.PUT, key, val)
+	return &clientv3.PutResponse{Header: f.header()}, nil
+}
+
+func (f *fakeEtcd) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextLease++
+	f.leases[f.nextLease] = true
+	return &clientv3.LeaseGrantResponse{ResponseHeader: f.header(), ID: f.nextLease, TTL: ttl}, nil
+}
+
+func (f *fakeEtcd) KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.leases[id] {
+		return nil, errors.New("requested lease not found")
+	}
+	return &clientv3.LeaseKeepAliveResponse{ResponseHeader: f.header(), ID: id}, nil
+}
+
+func (f *fakeEtcd) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.leases, id)
+	return &clientv3.LeaseRevokeResponse{Header: f.header()}, nil
+}
+
+func (f *fakeEtcd) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	op := clientv3.OpGet(key, opts...)
+	w := &fakeWatch{
+		key:    key,
+		prefix: op.IsOptsWithPrefix(),
+		ch:     make(chan clientv3.WatchResponse, 16),
+		ctx:    ctx,
+	}
+
+	f.mu.Lock()
+	var replay []*clientv3.Event
+	for _, ev := range f.history {
+		if ev.Kv.ModRevision >= op.Rev() && w.matches(string(ev.Kv.Key)) {
+			replay = append(replay, ev)
+		}
+	}
+	f.watchers = append(f.watchers, w)
+	f.mu.Unlock()
+
+	out := make(chan clientv3.WatchResponse)
+	go func() {
+		defer close(out)
+		defer f.unwatch(w)
+		for _, ev := range replay {
+			select {
+			case out <- clientv3.WatchResponse{Events: []*clientv3.Event{ev}}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			select {
+			case resp := <-w.ch:
+				select {
+				case out <- resp:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (f *fakeEtcd) unwatch(w *fakeWatch) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, other := range f.watchers {
+		if other == w {
+			f.watchers = append(f.watchers[:i], f.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Lease and Watcher both have Close
+func (f *fakeEtcd) Close() error {
+	return nil
+}
+
+// Lets every lease expire, dropping the liveness keys attached to them.
+func (f *fakeEtcd) expireAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	clear(f.leases)
+	for key := range f.kvs {
+		if strings.HasPrefix(key, ALIVE_PREFIX) {
+			delete(f.kvs, key)
+			f.emit(mvccpb.DELETE, key, "")
+		}
+	}
+}
+
+// Heartbeats, lease expiries, record updates and API reads all running at
+// the same time, meant to be run with -race.
+func TestRegistryConcurrentAccess(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	etcd := newFakeEtcd()
+	r := NewRegistry(etcd.client(ctx), nil)
+
+	const agents = 8
+	const rounds = 200
+	ids := make([]string, agents)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("agent-%d", i)
+		_, err := r.Update(ctx, ids[i], true, func(agent *AgentMetadata) {
+			agent.Name = ids[i]
+		})
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", ids[i], err)
+		}
+	}
+
+	watchDone := make(chan error, 1)
+	go func() {
+		watchDone <- r.WatchLiveness(ctx)
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, agents*2)
+	for _, id := range ids {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				if err := r.Heartbeat(ctx, id, 10); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := range rounds {
+				_, err := r.Update(ctx, id, false, func(agent *AgentMetadata) {
+					agent.Labels = map[string]string{"round": fmt.Sprint(i % 3)}
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for range rounds {
+			for _, id := range ids {
+				if _, ok := r.Get(id); !ok {
+					errs <- fmt.Errorf("%s disappeared", id)
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range rounds {
+			if got := len(r.List()); got != agents {
+				errs <- fmt.Errorf("listed %d agents, want %d", got, agents)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range rounds / 20 {
+			etcd.expireAll()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// one last heartbeat each, the watch has to bring every agent online
+	for _, id := range ids {
+		if err := r.Heartbeat(ctx, id, 10); err != nil {
+			t.Fatalf("heartbeat of %s failed: %v", id, err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		offline := 0
+		for _, agent := range r.List() {
+			if !agent.Online {
+				offline++
+			}
+		}
+		if offline == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d agents still offline after their heartbeat", offline)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-watchDone:
+		if err != nil {
+			t.Fatalf("WatchLiveness returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchLiveness did not stop after cancel")
+	}
+}
+
+// A failed read of the liveness keys is retried instead of stopping the
+// watch, which would bring central down.
+func TestWatchLivenessRetriesFailedGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	etcd := newFakeEtcd()
+	r := NewRegistry(etcd.client(ctx), nil)
+	_, err := r.Update(ctx, "agent", true, func(agent *AgentMetadata) {
+		agent.Name = "agent"
+	})
+	if err != nil {
+		t.Fatalf("failed to create agent: %v", err)
+	}
+	if err := r.Heartbeat(ctx, "agent", 10); err != nil {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+
+	etcd.mu.Lock()
+	etcd.failGets = 1
+	etcd.mu.Unlock()
+	watchDone := make(chan error, 1)
+	go func() {
+		watchDone <- r.WatchLiveness(ctx)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if agent, _ := r.Get("agent"); agent.Online {
+			break
+		}
+		select {
+		case err := <-watchDone:
+			t.Fatalf("WatchLiveness returned %v before cancel", err)
+		case <-deadline:
+			t.Fatal("agent still offline after the retry")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-watchDone:
+		if err != nil {
+			t.Fatalf("WatchLiveness returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchLiveness did not stop after cancel")
+	}
+}
@@ -0,0 +1,114 @@
+// Rollouts of deployment files across agents, see rollout.go in the server
+// package for how they run.
+package registry
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+)
+
+var ErrRolloutNotFound = errors.New("rollout not found")
+
+// Stores a new rollout, failing if the ID is taken.
+func (r *Registry) CreateRollout(ctx context.Context, rollout Rollout) error {
+	key := ROLLOUTS_PREFIX + rollout.ID
+	value, err := json.Marshal(rollout)
+	if err != nil {
+		return fmt.Errorf("failed to encode rollout %s: %w", rollout.ID, err)
+	}
+	resp, err := r.etcd.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("failed to save rollout %s: %w", rollout.ID, err)
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("rollout %s already exists", rollout.ID)
+	}
+	return nil
+}
+
+func (r *Registry) Rollout(ctx context.Context, id string) (Rollout, error) {
+	rollout, _, err := r.getRollout(ctx, id)
+	return rollout, err
+}
+
+// Every rollout, the most recent first.
+func (r *Registry) Rollouts(ctx context.Context) ([]Rollout, error) {
+	resp, err := r.etcd.Get(ctx, ROLLOUTS_PREFIX, clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rollouts: %w", err)
+	}
+	rollouts := []Rollout{}
+	for _, kv := range resp.Kvs {
+		rollout := Rollout{}
+		if err := json.Unmarshal(kv.Value, &rollout); err != nil {
+			return nil, fmt.Errorf("failed to decode rollout %s: %w", kv.Key, err)
+		}
+		rollouts = append(rollouts, rollout)
+	}
+	slices.SortFunc(rollouts, func(a, b Rollout) int {
+		return cmp.Or(cmp.Compare(b.CreatedAt, a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return rollouts, nil
+}
+
+// Read-modify-write of a rollout, retried when the key changed in the
+// meantime. Returns the rollout as written.
+func (r *Registry) UpdateRollout(
+	ctx context.Context,
+	id string,
+	fn func(rollout *Rollout),
+) (Rollout, error) {
+	key := ROLLOUTS_PREFIX + id
+	for {
+		rollout, revision, err := r.getRollout(ctx, id)
+		if err != nil {
+			return rollout, err
+		}
+		fn(&rollout)
+		value, err := json.Marshal(rollout)
+		if err != nil {
+			return rollout, fmt.Errorf("failed to encode rollout %s: %w", id, err)
+		}
+
+		resp, err := r.etcd.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+			Then(clientv3.OpPut(key, string(value))).
+			Commit()
+		if err != nil {
+			return rollout, fmt.Errorf("failed to save rollout %s: %w", id, err)
+		}
+		if resp.Succeeded {
+			return rollout, nil
+		}
+	}
+}
+
+// Returns the rollout along with the mod revision of its key.
+func (r *Registry) getRollout(ctx context.Context, id string) (Rollout, int64, error) {
+	rollout := Rollout{}
+	resp, err := r.etcd.Get(ctx, ROLLOUTS_PREFIX+id)
+	if err != nil {
+		return rollout, 0, fmt.Errorf("failed to load rollout %s: %w", id, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return rollout, 0, fmt.Errorf("rollout %s: %w", id, ErrRolloutNotFound)
+	}
+	if err := json.Unmarshal(resp.Kvs[0].Value, &rollout); err != nil {
+		return rollout, 0, fmt.Errorf("failed to decode rollout %s: %w", id, err)
+	}
+	if rollout.Agents == nil {
+		rollout.Agents = map[string]*RolloutAgent{}
+	}
+	return rollout, resp.Kvs[0].ModRevision, nil
+}
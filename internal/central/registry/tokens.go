@@ -0,0 +1,73 @@
+// Single-use bootstrap tokens for agent enrollment. Only a hash of each
+// token is kept in etcd, and expiry is handled by a lease.
+package registry
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+)
+
+// Issues a new bootstrap token valid for ttl.
+func (r *Registry) CreateToken(ctx context.Context, ttl time.Duration) (string, time.Time, error) {
+	seconds := int64(ttl.Seconds())
+	if seconds <= 0 {
+		return "", time.Time{}, fmt.Errorf("token ttl must be at least one second")
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+	expiresAt := time.Now().Add(time.Duration(seconds) * time.Second)
+
+	grant, err := r.etcd.Grant(ctx, seconds)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to grant token lease: %w", err)
+	}
+	_, err = r.etcd.Put(
+		ctx,
+		TOKENS_PREFIX+tokenKey(token),
+		fmt.Sprintf("%d", expiresAt.Unix()),
+		clientv3.WithLease(grant.ID),
+	)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store token: %w", err)
+	}
+	log.Infof("Bootstrap token issued, expires at %s", expiresAt.Format(time.RFC3339))
+	return token, expiresAt, nil
+}
+
+// Deletes the token if it exists, failing if it is unknown, expired or
+// already used.
+func (r *Registry) ConsumeToken(ctx context.Context, token string) error {
+	if token == "" {
+		return fmt.Errorf("bootstrap token is required")
+	}
+	key := TOKENS_PREFIX + tokenKey(token)
+	resp, err := r.etcd.Txn(ctx).
+		If(clientv3.Compare(clientv3.Version(key), ">", 0)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("failed to consume token: %w", err)
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("bootstrap token is invalid, expired or already used")
+	}
+	return nil
+}
+
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,143 @@
+// Agent service calls multiplexed over the stream an agent opens with
+// CentralService.Connect, for agents central cannot dial.
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	log "github.com/sirupsen/logrus"
+
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+// Implements grpc.ClientConnInterface, so generated clients such as
+// pba.NewAgentServiceClient work on top of it. Only unary calls are supported.
+type Tunnel struct {
+	stream pbc.CentralService_ConnectServer
+	sendMu sync.Mutex
+
+	nextID  atomic.Uint64
+	mu      sync.Mutex
+	pending map[uint64]chan *pbc.TunnelResponse
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewTunnel(stream pbc.CentralService_ConnectServer) *Tunnel {
+	return &Tunnel{
+		stream:  stream,
+		pending: make(map[uint64]chan *pbc.TunnelResponse),
+		done:    make(chan struct{}),
+	}
+}
+
+// Dispatches responses to the pending calls until the agent disconnects or
+// the tunnel is closed. Meant to be returned from the Connect handler.
+func (t *Tunnel) Serve() error {
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			resp, err := t.stream.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			t.mu.Lock()
+			ch, ok := t.pending[resp.GetCallId()]
+			delete(t.pending, resp.GetCallId())
+			t.mu.Unlock()
+			if !ok {
+				log.Warnf("Tunnel response for unknown call %d dropped", resp.GetCallId())
+				continue
+			}
+			ch <- resp
+		}
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-t.done:
+	}
+	t.Close()
+	return err
+}
+
+// Fails every pending and future call, Serve returns shortly after.
+func (t *Tunnel) Close() {
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+func (t *Tunnel) Invoke(
+	ctx context.Context,
+	method string,
+	args any,
+	reply any,
+	opts ...grpc.CallOption,
+) error {
+	req, ok := args.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "tunnel: unsupported request type %T", args)
+	}
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return status.Errorf(codes.Internal, "tunnel: failed to encode request: %v", err)
+	}
+
+	id := t.nextID.Add(1)
+	ch := make(chan *pbc.TunnelResponse, 1)
+	t.mu.Lock()
+	t.pending[id] = ch
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, id)
+		t.mu.Unlock()
+	}()
+
+	t.sendMu.Lock()
+	err = t.stream.Send(&pbc.TunnelRequest{
+		CallId:  &id,
+		Method:  &method,
+		Payload: payload,
+	})
+	t.sendMu.Unlock()
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "tunnel: failed to send request: %v", err)
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.GetCode() != int32(codes.OK) {
+			return status.Error(codes.Code(resp.GetCode()), resp.GetError())
+		}
+		if err := proto.Unmarshal(resp.GetPayload(), reply.(proto.Message)); err != nil {
+			return status.Errorf(codes.Internal, "tunnel: failed to decode response: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-t.done:
+		return status.Error(codes.Unavailable, "tunnel closed")
+	}
+}
+
+func (t *Tunnel) NewStream(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	method string,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	return nil, status.Error(codes.Unimplemented, fmt.Sprintf("tunnel: streaming call %s is not supported", method))
+}
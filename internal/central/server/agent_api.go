@@ -3,35 +3,127 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
-	"strconv"
-	"time"
 
+	"github.com/gogo/protobuf/proto"
 	mux "github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/labels"
 
+	. "github.com/Coosis/go-k8s-cord/internal/central/deployment"
 	. "github.com/Coosis/go-k8s-cord/internal/central/model"
 
 	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
 )
 
 const (
+	AGENT                    = "/api/v1/agent/{agent_id}"
 	AGENT_STATUS             = "/api/v1/agent/{agent_id}/status"
 	AGENT_DEPLOYMENTS        = "/api/v1/agent/{agent_id}/deployments"
 	AGENT_DEPLOYMENTS_APPLY  = "/api/v1/agent/{agent_id}/deployments/apply"
+	AGENT_DEPLOYMENTS_DIFF   = "/api/v1/agent/{agent_id}/deployments/diff"
 	AGENT_DEPLOYMENTS_REMOVE = "/api/v1/agent/{agent_id}/deployments/remove"
 	AGENT_DEPLOYMENTS_HASH   = "/api/v1/agent/{agent_id}/deployments/hash"
+	AGENT_NAMESPACES         = "/api/v1/agent/{agent_id}/namespaces"
 	AGENT_LIST               = "/api/v1/agent"
+
+	// ?namespace= value listing every namespace the agent allows
+	ALL_NAMESPACES = "*"
 )
 
+// Namespace selected by the ?namespace= query parameter, empty means the
+// agent's default namespace.
+func namespaceQuery(r *http.Request) (namespace string, all bool) {
+	namespace = r.URL.Query().Get("namespace")
+	if namespace == ALL_NAMESPACES {
+		return "", true
+	}
+	return namespace, false
+}
+
+// Maps the errors agents return for rejected requests to http status codes.
+func agentErrorStatus(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.FailedPrecondition:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (s *CentralServer) setupAgentRoutes() {
 	s.HandleFunc(AGENT_STATUS, s.agentStatus)
 	s.HandleFunc(AGENT_DEPLOYMENTS, s.agentDeployments)
 	s.HandleFunc(AGENT_DEPLOYMENTS_APPLY, s.agentApplyDeployments)
+	s.HandleFunc(AGENT_DEPLOYMENTS_DIFF, s.agentDiffDeployments)
 	s.HandleFunc(AGENT_DEPLOYMENTS_REMOVE, s.agentRemoveDeployments)
 	s.HandleFunc(AGENT_DEPLOYMENTS_HASH, s.agentDeploymentsHash)
+	s.HandleFunc(AGENT_NAMESPACES, s.agentNamespaces)
+	s.HandleFunc(AGENT_DRIFT, s.agentDrift)
+	s.HandleFunc(AGENT_DRIFT_SELF_HEAL, s.agentSelfHeal)
+	s.HandleFunc(AGENT_RECONCILE, s.agentReconcile)
+	s.HandleFunc(AGENT_TARGET, s.agentTarget)
 	s.HandleFunc(AGENT_LIST, s.listAgents)
+	s.HandleFunc(AGENT, s.agentDeregister)
+}
+
+// DELETE /api/v1/agent/{agent_id}?drain=true
+func (s *CentralServer) agentDeregister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		log.Warn("Method not allowed for agent deregister endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	log.Debug("Handling agent deregister request")
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	if _, ok := s.agents.Get(agentID); !ok {
+		log.Warnf("Agent %s not found", agentID)
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	}
+	drain := r.URL.Query().Get("drain") == "true"
+
+	drained, err := s.decommissionAgent(r.Context(), agentID, drain)
+	if err != nil {
+		log.Errorf("Failed to deregister agent %s: %v", agentID, err)
+		http.Error(w, "Failed to deregister agent: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]any{
+		"id":      agentID,
+		"drained": drained,
+	})
+	if err != nil {
+		log.Errorf("Failed to encode deregister response for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+type agentStatusResponse struct {
+	Name         string            `json:"name"`
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	// empty when the agent follows central's HEAD
+	TargetRef    string            `json:"target_ref,omitempty"`
+	// commit the agent should be at, empty if the ref does not resolve
+	TargetCommit string            `json:"target_commit"`
+	// nil until the agent sends its first summary
+	Summary      *ClusterSummary   `json:"summary,omitempty"`
 }
 
 func (s *CentralServer) agentStatus(w http.ResponseWriter, r *http.Request) {
@@ -42,41 +134,33 @@ func (s *CentralServer) agentStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Debug("Handling agent status request")
 
-	cfg := GetCentralConfig()
-
 	vars := mux.Vars(r)
 	agentID := vars["agent_id"]
-	resp, err := s.etcd.Get(r.Context(), agentID)
-	if err != nil {
-		log.Errorf("Failed to get agent status for %s: %v", agentID, err)
-		http.Error(w, "Failed to get agent status", http.StatusInternalServerError)
+	agent, ok := s.agents.Get(agentID)
+	if !ok {
+		log.Warnf("Agent %s not found", agentID)
+		http.Error(w, "Agent not found", http.StatusNotFound)
 		return
 	}
-	agent := s.agents[agentID]
 	onlineOrNot := "offline"
-	var mx int64
-	mx = 0
-	for _, kv := range resp.Kvs {
-		cval, err := strconv.ParseInt(string(kv.Value), 10, 64)
-		if err != nil {
-			log.Errorf("Failed to parse agent status for %s: %v", agentID, err)
-			http.Error(w, "Failed to parse agent status", http.StatusInternalServerError)
-			return
-		}
-		mx = max(mx, cval)
-	}
-	valid := time.Now().Add(time.Second * -time.Duration(cfg.AliveInterval)).Unix()
-	if mx < valid {
-		onlineOrNot = "offline"
-	} else {
+	if agent.Online {
 		onlineOrNot = "online"
 	}
 
+	targetCommit, err := ResolveRef(s.repo, agent.TargetRef)
+	if err != nil {
+		log.Warnf("Target of agent %s does not resolve: %v", agentID, err)
+	}
+
 	name := agent.Name
 	w.Header().Set("Content-Type", "application/json")
-	response := map[string]string{
-		"name":   name,
-		"status": onlineOrNot,
+	response := agentStatusResponse{
+		Name:         name,
+		Status:       onlineOrNot,
+		Labels:       agent.Labels,
+		TargetRef:    agent.TargetRef,
+		TargetCommit: targetCommit,
+		Summary:      agent.Summary,
 	}
 	err = json.NewEncoder(w).Encode(response)
 	if err != nil {
@@ -98,11 +182,20 @@ func (s *CentralServer) agentDeployments(w http.ResponseWriter, r *http.Request)
 
 	vars := mux.Vars(r)
 	agentID := vars["agent_id"]
-	client := pba.NewAgentServiceClient(s.agents[agentID].AgentConn)
-	resp, err := client.ListDeployments(r.Context(), &pba.ListDeploymentsRequest{})
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	namespace, all := namespaceQuery(r)
+	resp, err := client.ListDeployments(r.Context(), &pba.ListDeploymentsRequest{
+		Namespace:     proto.String(namespace),
+		AllNamespaces: proto.Bool(all),
+	})
 	if err != nil {
 		log.Errorf("Failed to list deployments for agent %s: %v", agentID, err)
-		http.Error(w, "Failed to list deployments: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to list deployments: "+err.Error(), agentErrorStatus(err))
 		return
 	}
 
@@ -111,12 +204,14 @@ func (s *CentralServer) agentDeployments(w http.ResponseWriter, r *http.Request)
 		metadata = append(metadata, map[string]any{
 			"apiVersion":        deployment.GetApiVersion(),
 			"name":              deployment.GetName(),
+			"namespace":         deployment.GetNamespace(),
 			"uid":               deployment.GetUid(),
 			"availableReplicas": deployment.GetAvailableReplicas(),
 			"replicas":          deployment.GetReplicas(),
 			"readyReplicas":     deployment.GetReadyReplicas(),
 			"creationTimestamp": deployment.GetCreationTimestamp(),
 			"updatedReplicas":   deployment.GetUpdatedReplicas(),
+			"paused":            deployment.GetPaused(),
 		})
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -129,6 +224,37 @@ func (s *CentralServer) agentDeployments(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+func (s *CentralServer) agentNamespaces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn("Method not allowed for agent namespaces endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	resp, err := client.ListNamespaces(r.Context(), &pba.ListNamespacesRequest{})
+	if err != nil {
+		log.Errorf("Failed to list namespaces for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to list namespaces: "+err.Error(), agentErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp.GetNamespaces())
+	if err != nil {
+		log.Errorf("Failed to encode namespaces for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode namespaces: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (s *CentralServer) listAgents(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		log.Warn("Method not allowed for list agents endpoint")
@@ -136,16 +262,30 @@ func (s *CentralServer) listAgents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	selected, err := s.selectedAgents(r)
+	if err != nil {
+		log.Warn("Invalid agent selector: ", err)
+		http.Error(w, "Invalid selector: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 	var agents []map[string]string
-	for agentID, agent := range s.agents {
+	for _, agent := range selected {
+		mode := agent.ConnectionMode
+		if mode == CONNECTION_MODE_TUNNEL && !agent.Tunneled {
+			mode += " (disconnected)"
+		}
 		agents = append(agents, map[string]string{
-			"id":   agentID,
-			"name": agent.Name,
+			"id":       agent.ID,
+			"name":     agent.Name,
+			"endpoint": agent.GrpcEndpoint,
+			"version":  agent.Version,
+			"mode":     mode,
+			"labels":   labels.Set(agent.Labels).String(),
 		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(agents)
+	err = json.NewEncoder(w).Encode(agents)
 	if err != nil {
 		log.Errorf("Failed to encode agents: %v", err)
 		http.Error(w, "Failed to encode agents: "+err.Error(), http.StatusInternalServerError)
@@ -157,6 +297,45 @@ type applyDeploymentsPayload struct {
 	DeploymentFiles []string `json:"deployment_files"`
 }
 
+// Applies the files on the agent, shipped as a bundle when ship_bundles is
+// set, and records which files were applied.
+func (s *CentralServer) applyDeployments(
+	ctx context.Context,
+	client pba.AgentServiceClient,
+	agentID string,
+	files []string,
+	namespace string,
+) (*pba.ApplyDeploymentsResponse, error) {
+	req := &pba.ApplyDeploymentsRequest{
+		DeploymentName: files,
+		Namespace:      proto.String(namespace),
+	}
+	var resp *pba.ApplyDeploymentsResponse
+	var err error
+	if GetCentralConfig().ShipBundles {
+		err = s.withBundle(agentID, files, func(bundle *pba.Bundle, hash string, commit string) error {
+			req.Bundle = bundle
+			req.BundleHash = proto.String(hash)
+			req.Commit = proto.String(commit)
+			resp, err = client.ApplyDeployments(ctx, req)
+			return err
+		})
+	} else {
+		var commit string
+		commit, err = s.requestedCommitOf(agentID)
+		if err != nil {
+			return nil, err
+		}
+		req.Commit = proto.String(commit)
+		resp, err = client.ApplyDeployments(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.recordApplied(ctx, agentID, namespace, resp.GetCommit(), req.GetBundleHash(), resp.GetObjects())
+	return resp, nil
+}
+
 func (s *CentralServer) agentApplyDeployments(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		log.Warn("Method not allowed for agent apply deployments endpoint")
@@ -167,33 +346,127 @@ func (s *CentralServer) agentApplyDeployments(w http.ResponseWriter, r *http.Req
 
 	vars := mux.Vars(r)
 	agentID := vars["agent_id"]
-	client := pba.NewAgentServiceClient(s.agents[agentID].AgentConn)
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
 
 	var payload applyDeploymentsPayload
-	err := json.NewDecoder(r.Body).Decode(&payload)
+	err = json.NewDecoder(r.Body).Decode(&payload)
 	if err != nil {
 		log.Errorf("Failed to decode deployment files for agent %s: %v", agentID, err)
 		http.Error(w, "Failed to decode deployment files: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	_, err = client.ApplyDeployments(r.Context(), &pba.ApplyDeploymentsRequest{
-		DeploymentName: payload.DeploymentFiles,
-	})
+	resp, err := s.applyDeployments(r.Context(), client, agentID, payload.DeploymentFiles, r.URL.Query().Get("namespace"))
 	if err != nil {
 		log.Errorf("Failed to apply deployments for agent %s: %v", agentID, err)
-		http.Error(w, "Failed to apply deployments: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to apply deployments: "+err.Error(), agentErrorStatus(err))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Deployments applied successfully"))
+	report := newObjectsReport(resp.GetSuccess(), resp.GetObjects())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(report.statusCode())
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		log.Errorf("Failed to encode apply result for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode apply result: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// Same payload as apply, nothing is changed on the agent.
+func (s *CentralServer) agentDiffDeployments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn("Method not allowed for agent diff deployments endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	log.Debug("Handling diff deployments request")
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var payload applyDeploymentsPayload
+	err = json.NewDecoder(r.Body).Decode(&payload)
+	if err != nil {
+		log.Errorf("Failed to decode deployment files for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to decode deployment files: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := &pba.DiffDeploymentsRequest{
+		DeploymentName: payload.DeploymentFiles,
+		Namespace:      proto.String(r.URL.Query().Get("namespace")),
+	}
+	var resp *pba.DiffDeploymentsResponse
+	if GetCentralConfig().ShipBundles {
+		err = s.withBundle(agentID, payload.DeploymentFiles, func(bundle *pba.Bundle, hash string, commit string) error {
+			req.Bundle = bundle
+			req.BundleHash = proto.String(hash)
+			req.Commit = proto.String(commit)
+			resp, err = client.DiffDeployments(r.Context(), req)
+			return err
+		})
+	} else {
+		var commit string
+		commit, err = s.requestedCommitOf(agentID)
+		if err == nil {
+			req.Commit = proto.String(commit)
+			resp, err = client.DiffDeployments(r.Context(), req)
+		}
+	}
+	if err != nil {
+		log.Errorf("Failed to diff deployments for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to diff deployments: "+err.Error(), agentErrorStatus(err))
+		return
+	}
+
+	report := newObjectsReport(resp.GetSuccess(), resp.GetObjects())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(report.statusCode())
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		log.Errorf("Failed to encode diff result for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode diff result: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
 type removeDeploymentsPayload struct {
 	DeploymentFiles []string `json:"deployment_files"`
 }
 
+// Removes the deployments from the agent and drops the files they came
+// from from its desired state.
+func (s *CentralServer) removeDeployments(
+	ctx context.Context,
+	client pba.AgentServiceClient,
+	agentID string,
+	deployments []string,
+	namespace string,
+) (*pba.RemoveDeploymentsResponse, error) {
+	resp, err := client.RemoveDeployments(ctx, &pba.RemoveDeploymentsRequest{
+		DeploymentName: deployments,
+		Namespace:      proto.String(namespace),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.recordRemoved(ctx, agentID, resp.GetObjects())
+	return resp, nil
+}
+
 func (s *CentralServer) agentRemoveDeployments(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		log.Warn("Method not allowed for agent remove deployments endpoint")
@@ -204,27 +477,37 @@ func (s *CentralServer) agentRemoveDeployments(w http.ResponseWriter, r *http.Re
 
 	vars := mux.Vars(r)
 	agentID := vars["agent_id"]
-	client := pba.NewAgentServiceClient(s.agents[agentID].AgentConn)
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
 
 	var payload removeDeploymentsPayload
-	err := json.NewDecoder(r.Body).Decode(&payload)
+	err = json.NewDecoder(r.Body).Decode(&payload)
 	if err != nil {
 		log.Errorf("Failed to decode deployment files for agent %s: %v", agentID, err)
 		http.Error(w, "Failed to decode deployment files: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	_, err = client.RemoveDeployments(r.Context(), &pba.RemoveDeploymentsRequest{
-		DeploymentName: payload.DeploymentFiles,
-	})
+	resp, err := s.removeDeployments(r.Context(), client, agentID, payload.DeploymentFiles, r.URL.Query().Get("namespace"))
 	if err != nil {
 		log.Errorf("Failed to remove deployments for agent %s: %v", agentID, err)
-		http.Error(w, "Failed to remove deployments: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to remove deployments: "+err.Error(), agentErrorStatus(err))
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Deployments removed successfully"))
+	report := newObjectsReport(resp.GetSuccess(), resp.GetObjects())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(report.statusCode())
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		log.Errorf("Failed to encode remove result for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode remove result: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
 func(s *CentralServer) agentDeploymentsHash(w http.ResponseWriter, r *http.Request) {
@@ -237,7 +520,12 @@ func(s *CentralServer) agentDeploymentsHash(w http.ResponseWriter, r *http.Reque
 
 	vars := mux.Vars(r)
 	agentID := vars["agent_id"]
-	client := pba.NewAgentServiceClient(s.agents[agentID].AgentConn)
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
 
 	resp, err := client.GetDeploymentsHash(r.Context(), &pba.GetDeploymentsHashRequest{})
 	if err != nil {
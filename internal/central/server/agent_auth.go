@@ -0,0 +1,44 @@
+// binding between agent IDs and the client certificates they enrolled with
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	. "github.com/Coosis/go-k8s-cord/internal"
+)
+
+// Fingerprint of the verified client certificate of the grpc peer.
+func peerFingerprint(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "no peer information")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return "", status.Error(codes.Unauthenticated, "no client certificate presented")
+	}
+	return CertFingerprint(info.State.PeerCertificates[0]), nil
+}
+
+// Checks that the grpc peer presents the certificate the agent enrolled with.
+func(s *CentralServer) authorizeAgent(ctx context.Context, agentID string) error {
+	agent, ok := s.agents.Get(agentID)
+	if !ok {
+		return status.Errorf(codes.NotFound, "agent %s is not registered", agentID)
+	}
+	fingerprint, err := peerFingerprint(ctx)
+	if err != nil {
+		return err
+	}
+	if agent.CertFingerprint == "" ||
+		subtle.ConstantTimeCompare([]byte(agent.CertFingerprint), []byte(fingerprint)) != 1 {
+		return status.Errorf(codes.PermissionDenied, "certificate does not match agent %s", agentID)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// agent certificate issuance, on enrollment(see agent_register.go) and renewal
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal"
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+// Signs the CSR of an agent, the certificate is valid for the host part of
+// the agent's grpc endpoint only. Returns the certificate and its fingerprint.
+func(s *CentralServer) issueAgentCert(
+	csr []byte,
+	agentID string,
+	endpoint string,
+) ([]byte, string, error) {
+	if s.ca == nil {
+		return nil, "", status.Error(codes.FailedPrecondition, "central cannot sign certificates, ca.key is missing")
+	}
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = endpoint
+	}
+	cfg := GetCentralConfig()
+	ttl := time.Duration(cfg.AgentCertTTL) * time.Hour
+	certPEM, err := s.ca.SignAgentCSR(csr, agentID, host, ttl)
+	if err != nil {
+		return nil, "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse issued certificate: %w", err)
+	}
+	return certPEM, CertFingerprint(cert), nil
+}
+
+func(s *CentralServer) RenewCertificate(
+	ctx context.Context,
+	req *pbc.RenewCertificateRequest,
+) (*pbc.RenewCertificateResponse, error) {
+	id := req.GetAgentId()
+	log.Debug("Certificate renewal requested by agent: ", id)
+	if err := s.authorizeAgent(ctx, id); err != nil {
+		log.Warnf("Certificate renewal for agent %s rejected: %v", id, err)
+		return nil, err
+	}
+	agent, _ := s.agents.Get(id)
+
+	certPEM, fingerprint, err := s.issueAgentCert(req.GetCsr(), id, agent.GrpcEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.agents.Update(ctx, id, false, func(agent *AgentMetadata) {
+		agent.CertFingerprint = fingerprint
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbc.RenewCertificateResponse{
+		Certificate: certPEM,
+		CaCertificate: s.ca.CertificatePEM(),
+	}, nil
+}
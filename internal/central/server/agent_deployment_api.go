@@ -0,0 +1,432 @@
+// operations on a single deployment of an agent, ?namespace= is the
+// deployment's namespace(the agent's default namespace when empty).
+// Look for "agent_page.go" for the htmx integration.
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gogo/protobuf/proto"
+	mux "github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+const (
+	AGENT_DEPLOYMENT_ROLLOUT  = "/api/v1/agent/{agent_id}/deployments/{name}/rollout"
+	AGENT_DEPLOYMENT_HISTORY  = "/api/v1/agent/{agent_id}/deployments/{name}/history"
+	AGENT_DEPLOYMENT_ROLLBACK = "/api/v1/agent/{agent_id}/deployments/{name}/rollback"
+	AGENT_DEPLOYMENT_SCALE    = "/api/v1/agent/{agent_id}/deployments/{name}/scale"
+	AGENT_DEPLOYMENT_RESTART  = "/api/v1/agent/{agent_id}/deployments/{name}/restart"
+	AGENT_DEPLOYMENT_PAUSE    = "/api/v1/agent/{agent_id}/deployments/{name}/pause"
+	AGENT_DEPLOYMENT_RESUME   = "/api/v1/agent/{agent_id}/deployments/{name}/resume"
+)
+
+type deploymentCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason"`
+	Message            string `json:"message"`
+	LastUpdateTime     int64  `json:"last_update_time"`
+	LastTransitionTime int64  `json:"last_transition_time"`
+}
+
+type rolloutStatusResponse struct {
+	Name                string                `json:"name"`
+	Namespace           string                `json:"namespace"`
+	Revision            int64                 `json:"revision"`
+	Generation          int64                 `json:"generation"`
+	ObservedGeneration  int64                 `json:"observed_generation"`
+	Replicas            int32                 `json:"replicas"`
+	UpdatedReplicas     int32                 `json:"updated_replicas"`
+	ReadyReplicas       int32                 `json:"ready_replicas"`
+	AvailableReplicas   int32                 `json:"available_replicas"`
+	UnavailableReplicas int32                 `json:"unavailable_replicas"`
+	Complete            bool                  `json:"complete"`
+	Stuck               bool                  `json:"stuck"`
+	Paused              bool                  `json:"paused"`
+	Message             string                `json:"message"`
+	Conditions          []deploymentCondition `json:"conditions"`
+}
+
+type deploymentRevision struct {
+	Revision          int64    `json:"revision"`
+	ReplicaSet        string   `json:"replica_set"`
+	Images            []string `json:"images"`
+	ChangeCause       string   `json:"change_cause,omitempty"`
+	Replicas          int32    `json:"replicas"`
+	CreationTimestamp int64    `json:"creation_timestamp"`
+	Current           bool     `json:"current"`
+}
+
+// Revision 0(or an empty body) goes back to the previous revision.
+type rollbackPayload struct {
+	Revision int64 `json:"revision"`
+}
+
+type rollbackResponse struct {
+	Revision int64 `json:"revision"`
+	Changed  bool  `json:"changed"`
+}
+
+type scalePayload struct {
+	// required, a missing value is not taken as 0
+	Replicas *int32 `json:"replicas"`
+}
+
+type scaleResponse struct {
+	PreviousReplicas int32 `json:"previous_replicas"`
+	Replicas         int32 `json:"replicas"`
+}
+
+type restartResponse struct {
+	RestartedAt string `json:"restarted_at"`
+}
+
+// Answer of pause and resume, Changed is false when the deployment already
+// was in that state.
+type pausedResponse struct {
+	Paused  bool `json:"paused"`
+	Changed bool `json:"changed"`
+}
+
+func (s *CentralServer) setupAgentDeploymentRoutes() {
+	s.HandleFunc(AGENT_DEPLOYMENT_ROLLOUT, s.agentDeploymentRollout)
+	s.HandleFunc(AGENT_DEPLOYMENT_HISTORY, s.agentDeploymentHistory)
+	s.HandleFunc(AGENT_DEPLOYMENT_ROLLBACK, s.agentDeploymentRollback)
+	s.HandleFunc(AGENT_DEPLOYMENT_SCALE, s.agentDeploymentScale)
+	s.HandleFunc(AGENT_DEPLOYMENT_RESTART, s.agentDeploymentRestart)
+	s.HandleFunc(AGENT_DEPLOYMENT_PAUSE, s.agentDeploymentPause)
+	s.HandleFunc(AGENT_DEPLOYMENT_RESUME, s.agentDeploymentResume)
+}
+
+func newRolloutStatusResponse(status *pba.DeploymentRolloutStatus) rolloutStatusResponse {
+	conditions := []deploymentCondition{}
+	for _, c := range status.GetConditions() {
+		conditions = append(conditions, deploymentCondition{
+			Type:               c.GetType(),
+			Status:             c.GetStatus(),
+			Reason:             c.GetReason(),
+			Message:            c.GetMessage(),
+			LastUpdateTime:     c.GetLastUpdateTime(),
+			LastTransitionTime: c.GetLastTransitionTime(),
+		})
+	}
+	return rolloutStatusResponse{
+		Name:                status.GetName(),
+		Namespace:           status.GetNamespace(),
+		Revision:            status.GetRevision(),
+		Generation:          status.GetGeneration(),
+		ObservedGeneration:  status.GetObservedGeneration(),
+		Replicas:            status.GetReplicas(),
+		UpdatedReplicas:     status.GetUpdatedReplicas(),
+		ReadyReplicas:       status.GetReadyReplicas(),
+		AvailableReplicas:   status.GetAvailableReplicas(),
+		UnavailableReplicas: status.GetUnavailableReplicas(),
+		Complete:            status.GetComplete(),
+		Stuck:               status.GetStuck(),
+		Paused:              status.GetPaused(),
+		Message:             status.GetMessage(),
+		Conditions:          conditions,
+	}
+}
+
+func (s *CentralServer) agentDeploymentRollout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn("Method not allowed for agent deployment rollout endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	name := vars["name"]
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	resp, err := client.GetRolloutStatus(r.Context(), &pba.GetRolloutStatusRequest{
+		Name:      proto.String(name),
+		Namespace: proto.String(r.URL.Query().Get("namespace")),
+	})
+	if err != nil {
+		log.Errorf("Failed to get rollout status of %s for agent %s: %v", name, agentID, err)
+		http.Error(w, "Failed to get rollout status: "+err.Error(), agentErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newRolloutStatusResponse(resp.GetStatus())); err != nil {
+		log.Errorf("Failed to encode rollout status for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode rollout status", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Revisions of the deployment, oldest first.
+func (s *CentralServer) agentDeploymentHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn("Method not allowed for agent deployment history endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	name := vars["name"]
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	resp, err := client.GetDeploymentHistory(r.Context(), &pba.GetDeploymentHistoryRequest{
+		Name:      proto.String(name),
+		Namespace: proto.String(r.URL.Query().Get("namespace")),
+	})
+	if err != nil {
+		log.Errorf("Failed to get history of %s for agent %s: %v", name, agentID, err)
+		http.Error(w, "Failed to get deployment history: "+err.Error(), agentErrorStatus(err))
+		return
+	}
+
+	revisions := []deploymentRevision{}
+	for _, revision := range resp.GetRevisions() {
+		revisions = append(revisions, deploymentRevision{
+			Revision:          revision.GetRevision(),
+			ReplicaSet:        revision.GetReplicaSet(),
+			Images:            revision.GetImages(),
+			ChangeCause:       revision.GetChangeCause(),
+			Replicas:          revision.GetReplicas(),
+			CreationTimestamp: revision.GetCreationTimestamp(),
+			Current:           revision.GetCurrent(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(revisions); err != nil {
+		log.Errorf("Failed to encode deployment history for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode deployment history", http.StatusInternalServerError)
+		return
+	}
+}
+
+// POST {"revision": 3}, the agent's drift report shows the deployment out of
+// sync with its file until the file is applied again.
+func (s *CentralServer) agentDeploymentRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn("Method not allowed for agent deployment rollback endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	name := vars["name"]
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var payload rollbackPayload
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			log.Errorf("Failed to decode rollback payload for agent %s: %v", agentID, err)
+			http.Error(w, "Failed to decode payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if payload.Revision < 0 {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := client.RollbackDeployment(r.Context(), &pba.RollbackDeploymentRequest{
+		Name:      proto.String(name),
+		Namespace: proto.String(r.URL.Query().Get("namespace")),
+		Revision:  proto.Int64(payload.Revision),
+	})
+	if err != nil {
+		log.Errorf("Failed to roll back %s for agent %s: %v", name, agentID, err)
+		http.Error(w, "Failed to roll back deployment: "+err.Error(), agentErrorStatus(err))
+		return
+	}
+	log.Infof("Rolled back %s on agent %s to revision %d", name, agentID, resp.GetRevision())
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(rollbackResponse{
+		Revision: resp.GetRevision(),
+		Changed:  resp.GetChanged(),
+	})
+	if err != nil {
+		log.Errorf("Failed to encode rollback result for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode rollback result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// POST {"replicas": 3}. Like a rollback, scaling a deployment whose file sets
+// replicas shows up as drift.
+func (s *CentralServer) agentDeploymentScale(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn("Method not allowed for agent deployment scale endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	name := vars["name"]
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var payload scalePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		log.Errorf("Failed to decode scale payload for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to decode payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.Replicas == nil || *payload.Replicas < 0 {
+		http.Error(w, "replicas must be set and not negative", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := client.ScaleDeployment(r.Context(), &pba.ScaleDeploymentRequest{
+		Name:      proto.String(name),
+		Namespace: proto.String(r.URL.Query().Get("namespace")),
+		Replicas:  payload.Replicas,
+	})
+	if err != nil {
+		log.Errorf("Failed to scale %s for agent %s: %v", name, agentID, err)
+		http.Error(w, "Failed to scale deployment: "+err.Error(), agentErrorStatus(err))
+		return
+	}
+	log.Infof("Scaled %s on agent %s from %d to %d replicas", name, agentID, resp.GetPreviousReplicas(), resp.GetReplicas())
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(scaleResponse{
+		PreviousReplicas: resp.GetPreviousReplicas(),
+		Replicas:         resp.GetReplicas(),
+	})
+	if err != nil {
+		log.Errorf("Failed to encode scale result for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode scale result", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Rolling restart, refused(409) while the deployment is paused.
+func (s *CentralServer) agentDeploymentRestart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn("Method not allowed for agent deployment restart endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	name := vars["name"]
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	resp, err := client.RestartDeployment(r.Context(), &pba.RestartDeploymentRequest{
+		Name:      proto.String(name),
+		Namespace: proto.String(r.URL.Query().Get("namespace")),
+	})
+	if err != nil {
+		log.Errorf("Failed to restart %s for agent %s: %v", name, agentID, err)
+		http.Error(w, "Failed to restart deployment: "+err.Error(), agentErrorStatus(err))
+		return
+	}
+	log.Infof("Restarted %s on agent %s", name, agentID)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(restartResponse{
+		RestartedAt: resp.GetRestartedAt(),
+	})
+	if err != nil {
+		log.Errorf("Failed to encode restart result for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode restart result", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *CentralServer) agentDeploymentPause(w http.ResponseWriter, r *http.Request) {
+	s.agentDeploymentSetPaused(w, r, true)
+}
+
+func (s *CentralServer) agentDeploymentResume(w http.ResponseWriter, r *http.Request) {
+	s.agentDeploymentSetPaused(w, r, false)
+}
+
+func (s *CentralServer) agentDeploymentSetPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	action := "resume"
+	if paused {
+		action = "pause"
+	}
+	if r.Method != http.MethodPost {
+		log.Warnf("Method not allowed for agent deployment %s endpoint", action)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	name := vars["name"]
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		log.Errorf("Failed to reach agent %s: %v", agentID, err)
+		http.Error(w, "Failed to reach agent: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	namespace := proto.String(r.URL.Query().Get("namespace"))
+	var changed bool
+	if paused {
+		var resp *pba.PauseDeploymentResponse
+		resp, err = client.PauseDeployment(r.Context(), &pba.PauseDeploymentRequest{
+			Name:      proto.String(name),
+			Namespace: namespace,
+		})
+		changed = resp.GetChanged()
+	} else {
+		var resp *pba.ResumeDeploymentResponse
+		resp, err = client.ResumeDeployment(r.Context(), &pba.ResumeDeploymentRequest{
+			Name:      proto.String(name),
+			Namespace: namespace,
+		})
+		changed = resp.GetChanged()
+	}
+	if err != nil {
+		log.Errorf("Failed to %s %s for agent %s: %v", action, name, agentID, err)
+		http.Error(w, "Failed to "+action+" deployment: "+err.Error(), agentErrorStatus(err))
+		return
+	}
+	log.Infof("Set paused=%t on %s on agent %s, changed: %t", paused, name, agentID, changed)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(pausedResponse{
+		Paused:  paused,
+		Changed: changed,
+	})
+	if err != nil {
+		log.Errorf("Failed to encode %s result for agent %s: %v", action, agentID, err)
+		http.Error(w, "Failed to encode "+action+" result", http.StatusInternalServerError)
+		return
+	}
+}
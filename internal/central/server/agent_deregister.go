@@ -0,0 +1,67 @@
+// agent deregistration, both the grpc server-side implementation(agent initiated)
+// and the central initiated decommissioning used by the api.
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+
+	log "github.com/sirupsen/logrus"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+func(s *CentralServer) DeregisterAgent(
+	ctx context.Context,
+	req *pbc.DeregisterAgentRequest,
+) (*pbc.DeregisterAgentResponse, error) {
+	id := req.GetAgentId()
+	log.Debug("Deregistration requested by agent: ", id)
+	if err := s.authorizeAgent(ctx, id); err != nil {
+		log.Warnf("Deregistration of agent %s rejected: %v", id, err)
+		return nil, err
+	}
+	if err := s.agents.Remove(ctx, id); err != nil {
+		return nil, err
+	}
+	return &pbc.DeregisterAgentResponse{
+		Success: proto.Bool(true),
+	}, nil
+}
+
+// Asks the agent to clear its registration(draining its workloads first if
+// requested), then removes it from the registry. An unreachable agent is
+// still removed, it clears its registration once its heartbeat is rejected.
+func(s *CentralServer) decommissionAgent(
+	ctx context.Context,
+	agentID string,
+	drain bool,
+) ([]string, error) {
+	if _, ok := s.agents.Get(agentID); !ok {
+		return nil, fmt.Errorf("agent %s not found", agentID)
+	}
+
+	var drained []string
+	client, err := s.agentClient(agentID)
+	if err == nil {
+		var resp *pba.DecommissionResponse
+		resp, err = client.Decommission(ctx, &pba.DecommissionRequest{
+			Drain: proto.Bool(drain),
+		})
+		drained = resp.GetDrained()
+	}
+	if err != nil {
+		if drain {
+			return nil, fmt.Errorf("failed to drain agent %s: %w", agentID, err)
+		}
+		log.Warnf("Failed to decommission agent %s, removing it anyway: %v", agentID, err)
+	}
+
+	if err := s.agents.Remove(ctx, agentID); err != nil {
+		return drained, err
+	}
+	return drained, nil
+}
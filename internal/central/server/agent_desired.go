@@ -0,0 +1,115 @@
+// desired state of the agents, recorded from the apply and remove results
+package server
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+func objectRef(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// Records the files with at least one object applied, at the given commit
+// and from the bundle with the given hash, if any.
+func (s *CentralServer) recordApplied(
+	ctx context.Context,
+	agentID string,
+	namespace string,
+	commit string,
+	bundle string,
+	results []*pba.ObjectResult,
+) {
+	files := map[string]DesiredFile{}
+	now := time.Now().Unix()
+	for _, result := range results {
+		if result.GetStatus() == pba.ObjectStatus_FAILED || result.GetFile() == "" {
+			continue
+		}
+		file, ok := files[result.GetFile()]
+		if !ok {
+			file = DesiredFile{
+				Commit:    commit,
+				Namespace: namespace,
+				Bundle:    bundle,
+				Objects:   []string{},
+				AppliedAt: now,
+			}
+		}
+		file.Objects = append(file.Objects, objectRef(result.GetKind(), result.GetNamespace(), result.GetName()))
+		files[result.GetFile()] = file
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	err := s.agents.UpdateDesired(ctx, agentID, func(state *DesiredState) {
+		for name, file := range files {
+			state.Files[name] = file
+		}
+	})
+	if err != nil {
+		log.Errorf("Failed to record applied files for agent %s: %v", agentID, err)
+	}
+}
+
+// Drops the files the removed objects came from.
+func (s *CentralServer) recordRemoved(
+	ctx context.Context,
+	agentID string,
+	results []*pba.ObjectResult,
+) {
+	refs := []string{}
+	for _, result := range results {
+		if result.GetStatus() == pba.ObjectStatus_REMOVED {
+			refs = append(refs, objectRef(result.GetKind(), result.GetNamespace(), result.GetName()))
+		}
+	}
+	if len(refs) == 0 {
+		return
+	}
+
+	err := s.agents.UpdateDesired(ctx, agentID, func(state *DesiredState) {
+		for name, file := range state.Files {
+			if slices.ContainsFunc(file.Objects, func(ref string) bool {
+				return slices.Contains(refs, ref)
+			}) {
+				delete(state.Files, name)
+			}
+		}
+	})
+	if err != nil {
+		log.Errorf("Failed to record removed files for agent %s: %v", agentID, err)
+	}
+}
+
+// Recorded files of an agent, sent back with the drift reports so the agent
+// keeps checking them after a restart.
+func (s *CentralServer) desiredFiles(ctx context.Context, agentID string) []*pbc.DesiredFile {
+	state, err := s.agents.Desired(ctx, agentID)
+	if err != nil {
+		log.Errorf("Failed to load desired state of agent %s: %v", agentID, err)
+		return nil
+	}
+
+	files := []*pbc.DesiredFile{}
+	for name, file := range state.Files {
+		files = append(files, &pbc.DesiredFile{
+			File:       proto.String(name),
+			Namespace:  proto.String(file.Namespace),
+			Commit:     proto.String(file.Commit),
+			Objects:    file.Objects,
+			BundleHash: proto.String(file.Bundle),
+		})
+	}
+	return files
+}
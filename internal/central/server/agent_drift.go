@@ -0,0 +1,65 @@
+// drift reports sent by agents, see agent_drift_api.go for the http side
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+	. "github.com/Coosis/go-k8s-cord/internal/central/registry"
+
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+func(s *CentralServer) ReportDrift(
+	ctx context.Context,
+	req *pbc.ReportDriftRequest,
+) (*pbc.ReportDriftResponse, error) {
+	id := req.GetAgentId()
+	if err := s.authorizeAgent(ctx, id); err != nil {
+		log.Warnf("Drift report from agent %s rejected: %v", id, err)
+		return nil, err
+	}
+
+	report := &DriftReport{
+		CheckedFiles: req.GetCheckedFiles(),
+		Objects:      []DriftedObject{},
+		Timestamp:    req.GetTimestamp(),
+	}
+	for _, obj := range req.GetObjects() {
+		report.Objects = append(report.Objects, DriftedObject{
+			File:       obj.GetFile(),
+			APIVersion: obj.GetApiVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+			Diff:       obj.GetDiff(),
+			Error:      obj.GetError(),
+		})
+	}
+	if len(report.Objects) > 0 {
+		log.Infof("Agent %s reported %d drifted objects", id, len(report.Objects))
+	}
+
+	agent, err := s.agents.Update(ctx, id, false, func(agent *AgentMetadata) {
+		agent.Drift = report
+	})
+	if errors.Is(err, ErrAgentNotFound) {
+		return nil, status.Errorf(codes.NotFound, "agent %s is not registered", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbc.ReportDriftResponse{
+		Success:  proto.Bool(true),
+		SelfHeal: agent.SelfHeal,
+		Desired:  s.desiredFiles(ctx, id),
+	}, nil
+}
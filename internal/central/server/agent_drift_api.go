@@ -0,0 +1,115 @@
+// per-agent drift report and self-heal settings
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	mux "github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+	. "github.com/Coosis/go-k8s-cord/internal/central/registry"
+)
+
+const (
+	AGENT_DRIFT           = "/api/v1/agent/{agent_id}/drift"
+	AGENT_DRIFT_SELF_HEAL = "/api/v1/agent/{agent_id}/drift/self-heal"
+)
+
+type agentDriftResponse struct {
+	// nil until the agent sends its first report
+	Report   *DriftReport `json:"report"`
+	SelfHeal []string     `json:"self_heal"`
+}
+
+type selfHealPayload struct {
+	File    string `json:"file"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (s *CentralServer) agentDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn("Method not allowed for agent drift endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	agent, ok := s.agents.Get(agentID)
+	if !ok {
+		log.Warnf("Agent %s not found", agentID)
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	}
+
+	selfHeal := agent.SelfHeal
+	if selfHeal == nil {
+		selfHeal = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(agentDriftResponse{
+		Report:   agent.Drift,
+		SelfHeal: selfHeal,
+	})
+	if err != nil {
+		log.Errorf("Failed to encode drift report for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode drift report", http.StatusInternalServerError)
+		return
+	}
+}
+
+// POST {"file": "...", "enabled": true}, the agent picks the change up with
+// its next drift report.
+func (s *CentralServer) agentSelfHeal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn("Method not allowed for agent self-heal endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+	var payload selfHealPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		log.Errorf("Failed to decode self-heal payload for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to decode payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if payload.File == "" {
+		http.Error(w, "No file provided", http.StatusBadRequest)
+		return
+	}
+
+	agent, err := s.agents.Update(r.Context(), agentID, false, func(agent *AgentMetadata) {
+		agent.SelfHeal = slices.DeleteFunc(agent.SelfHeal, func(f string) bool {
+			return f == payload.File
+		})
+		if payload.Enabled {
+			agent.SelfHeal = append(agent.SelfHeal, payload.File)
+			slices.Sort(agent.SelfHeal)
+		}
+	})
+	if errors.Is(err, ErrAgentNotFound) {
+		log.Warnf("Agent %s not found", agentID)
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to update self-heal for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to update self-heal: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof("Self-heal of %s on agent %s set to %t", payload.File, agentID, payload.Enabled)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(agent.SelfHeal)
+	if err != nil {
+		log.Errorf("Failed to encode self-heal files for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
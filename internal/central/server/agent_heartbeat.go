@@ -3,16 +3,18 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	log "github.com/sirupsen/logrus"
 
 	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+	. "github.com/Coosis/go-k8s-cord/internal/central/registry"
 
 	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
 )
@@ -25,59 +27,51 @@ func(s *CentralServer) SendHeartbeat(
 	id := req.GetAgentId()
 	idstr := fmt.Sprintf("%s", id)
 	log.Debug("Heartbeat received from agent: ", id)
-	// Register the agent in etcd
-	now := time.Now().Unix()
-	nowstr := fmt.Sprintf("%d", now)
-	log.Debugf("Registering heartbeat for agent %s at %s", idstr, nowstr)
-	_, err := s.etcd.Put(ctx, idstr, nowstr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to register heartbeat in etcd: %w", err)
+	if err := s.authorizeAgent(ctx, idstr); err != nil {
+		log.Warnf("Heartbeat from agent %s rejected: %v", idstr, err)
+		return nil, err
 	}
-
-	if _, exists := s.agents[idstr]; !exists {
-		// If the agent is not registered, create a new entry
-		log.Debugf("Agent %s not found, creating new entry", idstr)
-		cred := credentials.NewTLS(s.tlsConfig)
-		// Create a gRPC connection to the agent
-		conn, err := grpc.NewClient(req.GetAgentGrpcEndpoint(), grpc.WithTransportCredentials(cred))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create gRPC connection to agent: %w", err)
-		}
-		s.agents[idstr] = &AgentMetadata{
-			Name: req.GetAgentName(), // Set the agent name from the request
-			GrpcEndpoint: req.GetAgentGrpcEndpoint(),
-			AgentConn: conn, // Store the gRPC connection
-		}
-	} else {
-		log.Debugf("Agent %s already registered, updating metadata", idstr)
-		// Update the agent metadata if it already exists
-		s.agents[idstr].Name = req.GetAgentName()
-		if s.agents[idstr].GrpcEndpoint != req.GetAgentGrpcEndpoint() {
-			// If the gRPC endpoint has changed, we need to create a new connection
-			log.Debugf("Agent %s gRPC endpoint changed, updating connection", idstr)
-			if s.agents[idstr].AgentConn != nil {
-				s.agents[idstr].AgentConn.Close() // Close the old connection
-			}
-			cred := credentials.NewTLS(s.tlsConfig)
-			// Create a gRPC connection to the agent
-			conn, err := grpc.NewClient(req.GetAgentGrpcEndpoint(), grpc.WithTransportCredentials(cred))
-			if err != nil {
-				return nil, fmt.Errorf("failed to create gRPC connection to agent: %w", err)
-			}
-			s.agents[idstr].AgentConn = conn
+	now := time.Now().Unix()
+	_, err := s.agents.Update(ctx, idstr, false, func(agent *AgentMetadata) {
+		agent.Name = req.GetAgentName()
+		agent.GrpcEndpoint = req.GetAgentGrpcEndpoint()
+		agent.Version = req.GetAgentVersion()
+		agent.ConnectionMode = req.GetConnectionMode()
+		if agent.ConnectionMode == "" {
+			agent.ConnectionMode = CONNECTION_MODE_DIAL
 		}
-		if s.agents[idstr].AgentConn == nil {
-			// If the connection is nil, create a new one
-			log.Debugf("Agent %s connection is nil, creating a new one", idstr)
-			cred := credentials.NewTLS(s.tlsConfig)
-			// Create a gRPC connection to the agent
-			conn, err := grpc.NewClient(req.GetAgentGrpcEndpoint(), grpc.WithTransportCredentials(cred))
-			if err != nil {
-				return nil, fmt.Errorf("failed to create gRPC connection to agent: %w", err)
+		agent.Labels = validLabels(idstr, req.GetLabels())
+		if summary := req.GetSummary(); summary != nil {
+			agent.Summary = &ClusterSummary{
+				KubernetesVersion: summary.GetKubernetesVersion(),
+				NodeCount:         summary.GetNodeCount(),
+				ReadyNodeCount:    summary.GetReadyNodeCount(),
+				CPUCapacity:       summary.GetCpuCapacity(),
+				CPUAllocatable:    summary.GetCpuAllocatable(),
+				MemoryCapacity:    summary.GetMemoryCapacity(),
+				MemoryAllocatable: summary.GetMemoryAllocatable(),
+				PodsByPhase:       summary.GetPodsByPhase(),
+				DeploymentsHash:   summary.GetDeploymentsHash(),
+				AgentVersion:      summary.GetAgentVersion(),
+				Timestamp:         summary.GetTimestamp(),
+				FetchError:        summary.GetFetchError(),
 			}
-			s.agents[idstr].AgentConn = conn
 		}
-		s.agents[idstr].GrpcEndpoint = req.GetAgentGrpcEndpoint()
+	})
+	if errors.Is(err, ErrAgentNotFound) {
+		// deregistered, or never registered in the first place
+		log.Warnf("Heartbeat from unknown agent %s rejected", idstr)
+		return nil, status.Errorf(codes.NotFound, "agent %s is not registered", idstr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Refresh the liveness lease in etcd
+	cfg := GetCentralConfig()
+	log.Debugf("Registering heartbeat for agent %s at %d", idstr, now)
+	if err := s.agents.Heartbeat(ctx, idstr, int64(cfg.AliveInterval)); err != nil {
+		return nil, err
 	}
 
 	return &pbc.HeartbeatResponse{
@@ -86,4 +80,3 @@ func(s *CentralServer) SendHeartbeat(
 		Timestamp: proto.Int64(time.Now().Unix()),
 	}, nil
 }
-
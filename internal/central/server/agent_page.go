@@ -6,9 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
-	. "github.com/Coosis/go-k8s-cord/internal/central/deployment"
 	. "github.com/Coosis/go-k8s-cord/internal/central/model"
 
 	mux "github.com/gorilla/mux"
@@ -17,11 +20,22 @@ import (
 
 const (
 	agentStatusEndpoint            = "http://localhost%s/api/v1/agent/%s/status"
-	agentDeploymentsEndpoint       = "http://localhost%s/api/v1/agent/%s/deployments"
-	agentDeploymentsHashEndpoint   = "http://localhost%s/api/v1/agent/%s/deployments/hash"
-	agentApplyDeploymentsEndpoint  = "http://localhost%s/api/v1/agent/%s/deployments/apply"
-	agentRemoveDeploymentsEndpoint = "http://localhost%s/api/v1/agent/%s/deployments/remove"
+	agentDeploymentsEndpoint       = "http://localhost%s/api/v1/agent/%s/deployments?namespace=%s"
+	agentApplyDeploymentsEndpoint  = "http://localhost%s/api/v1/agent/%s/deployments/apply?namespace=%s"
+	agentDiffDeploymentsEndpoint   = "http://localhost%s/api/v1/agent/%s/deployments/diff?namespace=%s"
+	agentRemoveDeploymentsEndpoint = "http://localhost%s/api/v1/agent/%s/deployments/remove?namespace=%s"
+	agentRolloutStatusEndpoint     = "http://localhost%s/api/v1/agent/%s/deployments/%s/rollout?namespace=%s"
+	agentHistoryEndpoint           = "http://localhost%s/api/v1/agent/%s/deployments/%s/history?namespace=%s"
+	agentRollbackEndpoint          = "http://localhost%s/api/v1/agent/%s/deployments/%s/rollback?namespace=%s"
+	// scale, restart, pause or resume
+	agentDeploymentActionEndpoint  = "http://localhost%s/api/v1/agent/%s/deployments/%s/%s?namespace=%s"
+	agentNamespacesEndpoint        = "http://localhost%s/api/v1/agent/%s/namespaces"
+	agentDriftEndpoint             = "http://localhost%s/api/v1/agent/%s/drift"
+	agentSelfHealEndpoint          = "http://localhost%s/api/v1/agent/%s/drift/self-heal"
+	agentReconcileEndpoint         = "http://localhost%s/api/v1/agent/%s/reconcile"
+	agentTargetEndpoint            = "http://localhost%s/api/v1/agent/%s/target"
 	agentListEndpoint              = "http://localhost%s/api/v1/agent"
+	agentDeregisterEndpoint        = "http://localhost%s/api/v1/agent/%s?drain=%t"
 )
 
 func (s *CentralServer) setupAgentsHTML() {
@@ -67,14 +81,14 @@ func (s *CentralServer) setupAgentsHTML() {
 				continue
 			}
 
-			agentStatus := make(map[string]string)
+			var agentStatus agentStatusResponse
 			if err := json.NewDecoder(resp.Body).Decode(&agentStatus); err != nil {
 				log.Error("Failed to decode agent status: ", err)
 				agent["status"] = "Error..."
 				continue
 			}
-			status, ok := agentStatus["status"]
-			if !ok {
+			status := agentStatus.Status
+			if status == "" {
 				log.Error("Agent status not found in status response")
 				agent["status"] = "Error..."
 				continue
@@ -99,6 +113,7 @@ func (s *CentralServer) setupAgentsHTML() {
 	s.HandleFunc("/agent/{agent_id}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		agentID := vars["agent_id"]
+		namespace := r.URL.Query().Get("namespace")
 
 		// GET to agent status
 		endpoint := fmt.Sprintf(agentStatusEndpoint, cfg.HTTPSPort, agentID)
@@ -115,22 +130,16 @@ func (s *CentralServer) setupAgentsHTML() {
 			http.Error(w, "Failed to get agent status, status code: "+resp.Status, resp.StatusCode)
 			return
 		}
-		agentStatus := make(map[string]string)
+		var agentStatus agentStatusResponse
 		if err := json.NewDecoder(resp.Body).Decode(&agentStatus); err != nil {
 			log.Error("Failed to decode agent status: ", err)
 			http.Error(w, "Failed to decode agent status: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		agentName, ok := agentStatus["name"]
-		if !ok {
-			log.Error("Agent name not found in status response")
-			http.Error(w, "Agent name not found in status response", http.StatusInternalServerError)
-			return
-		}
-
-		agentStatusValue, ok := agentStatus["status"]
-		if !ok {
+		agentName := agentStatus.Name
+		agentStatusValue := agentStatus.Status
+		if agentStatusValue == "" {
 			log.Error("Agent status not found in status response")
 			http.Error(w, "Agent status not found in status response", http.StatusInternalServerError)
 			return
@@ -138,9 +147,23 @@ func (s *CentralServer) setupAgentsHTML() {
 
 		log.Debugf("Agent %s status: %s", agentName, agentStatusValue)
 
+		// GET to agent namespaces, the selector is left empty on failure
+		var namespaces []string
+		resp, err = http.Get(fmt.Sprintf(agentNamespacesEndpoint, cfg.HTTPSPort, agentID))
+		if err != nil {
+			log.Error("Failed to get agent namespaces: ", err)
+		} else {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				log.Error("Failed to get agent namespaces, status code: ", resp.StatusCode)
+			} else if err := json.NewDecoder(resp.Body).Decode(&namespaces); err != nil {
+				log.Error("Failed to decode agent namespaces: ", err)
+			}
+		}
+
 		// GET to agent deployments
 		var deployments []map[string]any
-		resp, err = http.Get(fmt.Sprintf(agentDeploymentsEndpoint, cfg.HTTPSPort, agentID))
+		resp, err = http.Get(fmt.Sprintf(agentDeploymentsEndpoint, cfg.HTTPSPort, agentID, url.QueryEscape(namespace)))
 		if err != nil {
 			log.Error("Failed to get agent deployments: ", err)
 			http.Error(w, "Failed to get agent deployments: "+err.Error(), http.StatusInternalServerError)
@@ -157,39 +180,18 @@ func (s *CentralServer) setupAgentsHTML() {
 			return
 		}
 
-		// GET to agent deployments hash
-		resp, err = http.Get(fmt.Sprintf(agentDeploymentsHashEndpoint, cfg.HTTPSPort, agentID))
-		if err != nil {
-			log.Error("Failed to get agent deployments hash: ", err)
-			http.Error(w, "Failed to get agent deployments hash: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		hash := make(map[string]string)
-		if resp.StatusCode != http.StatusOK {
-			log.Error("Failed to get agent deployments hash, status code: ", resp.StatusCode)
-			http.Error(w, "Failed to get agent deployments hash, status code: "+resp.Status, resp.StatusCode)
-			return
-		}
-		defer resp.Body.Close()
-		if err := json.NewDecoder(resp.Body).Decode(&hash); err != nil {
-			log.Error("Failed to decode agent deployments hash: ", err)
-			http.Error(w, "Failed to decode agent deployments hash: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		deploymentsHash, ok := hash["hash"]
-		if !ok {
-			log.Error("Deployments hash not found in response")
-			http.Error(w, "Deployments hash not found in response", http.StatusInternalServerError)
-			return
-		}
-		centralHash, err := DeploymentsHash(s.repo)
-		if err != nil {
-			log.Error("Failed to get central deployments hash: ", err)
-			http.Error(w, "Failed to get central deployments hash: "+err.Error(), http.StatusInternalServerError)
-			return
+		// the agent's deployments hash comes with its heartbeat summary
+		deploymentsHash := ""
+		if agentStatus.Summary != nil {
+			deploymentsHash = agentStatus.Summary.DeploymentsHash
 		}
+		// compared against the agent's target revision, not central's HEAD
 		var hashMatch string
-		if deploymentsHash == centralHash {
+		if agentStatus.TargetCommit == "" {
+			hashMatch = "Target " + agentStatus.TargetRef + " does not resolve"
+		} else if deploymentsHash == "" {
+			hashMatch = "Sync status unknown, waiting for a heartbeat..."
+		} else if deploymentsHash == agentStatus.TargetCommit {
 			hashMatch = "Sync"
 		} else {
 			hashMatch = "Out of Sync, please wait..."
@@ -223,7 +225,13 @@ func (s *CentralServer) setupAgentsHTML() {
 			"AgentID":         agentID,
 			"Deployments":     deployments,
 			"HashMatch":       hashMatch,
+			"Labels":          agentStatus.Labels,
+			"TargetRef":       agentStatus.TargetRef,
+			"TargetCommit":    agentStatus.TargetCommit,
 			"DeploymentFiles": items,
+			"Summary":         agentStatus.Summary,
+			"Namespace":       namespace,
+			"Namespaces":      namespaces,
 		}
 		if err := agentTempl.Execute(w, htmlVars); err != nil {
 			log.Error("Failed to execute agent template: ", err)
@@ -236,9 +244,10 @@ func (s *CentralServer) setupAgentsHTML() {
 	s.HandleFunc("/agent/{agent_id}/deployments", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		agentid := vars["agent_id"]
+		namespace := r.URL.Query().Get("namespace")
 
 		// GET to agent deployments
-		resp, err := http.Get(fmt.Sprintf(agentDeploymentsEndpoint, cfg.HTTPSPort, agentid))
+		resp, err := http.Get(fmt.Sprintf(agentDeploymentsEndpoint, cfg.HTTPSPort, agentid, url.QueryEscape(namespace)))
 		if err != nil {
 			log.Error("Failed to get agent deployments: ", err)
 			http.Error(w, "Failed to get agent deployments: "+err.Error(), http.StatusInternalServerError)
@@ -262,6 +271,7 @@ func (s *CentralServer) setupAgentsHTML() {
 		htmlVars := map[string]any{
 			"AgentID":     agentid,
 			"Deployments": deployments,
+			"Namespace":   namespace,
 		}
 		if err := agentDeploymentsTempl.Execute(w, htmlVars); err != nil {
 			log.Error("Failed to execute agent deployments template: ", err)
@@ -270,6 +280,86 @@ func (s *CentralServer) setupAgentsHTML() {
 		}
 	})
 
+	// shown before an apply is confirmed
+	agentDiffTempl := template.Must(template.ParseFiles("templates/agent_diff.html"))
+	s.HandleFunc("/agent/{agent_id}/deployments/diff", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Warn("Method not allowed for agent deployments diff endpoint")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			log.Error("Failed to parse form data: ", err)
+			http.Error(w, "Failed to parse form data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		agentid := mux.Vars(r)["agent_id"]
+		deploymentFiles := r.Form["deployment_files"]
+		if len(deploymentFiles) == 0 {
+			log.Warn("No deployment files provided for diff")
+			http.Error(w, "No deployment files provided", http.StatusBadRequest)
+			return
+		}
+		// same namespace rule as the apply below
+		view := r.Form.Get("view")
+		namespace := view
+		if namespace == ALL_NAMESPACES {
+			namespace = ""
+		}
+
+		payload := applyDeploymentsPayload{
+			DeploymentFiles: deploymentFiles,
+		}
+		jsonBody, err := json.Marshal(payload)
+		if err != nil {
+			log.Error("Failed to marshal deployment files to JSON: ", err)
+			http.Error(w, "Failed to marshal deployment files: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := http.Post(
+			fmt.Sprintf(agentDiffDeploymentsEndpoint, cfg.HTTPSPort, agentid, url.QueryEscape(namespace)),
+			"application/json",
+			bytes.NewReader(jsonBody),
+		)
+		if err != nil {
+			log.Error("Failed to diff deployments: ", err)
+			http.Error(w, "Failed to diff deployments: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		var report objectsReport
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			log.Error("Failed to decode diff report, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to diff deployments, status code: "+resp.Status, http.StatusInternalServerError)
+			return
+		}
+
+		// values sent by the confirm button, the same as the ones received
+		vals, err := json.Marshal(map[string]any{
+			"deployment_files": deploymentFiles,
+			"view":             view,
+		})
+		if err != nil {
+			log.Error("Failed to marshal apply values: ", err)
+			http.Error(w, "Failed to marshal apply values: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		htmlVars := map[string]any{
+			"AgentID":   agentid,
+			"Objects":   report.Objects,
+			"ApplyVals": string(vals),
+		}
+		if err := agentDiffTempl.Execute(w, htmlVars); err != nil {
+			log.Error("Failed to execute agent diff template: ", err)
+			http.Error(w, "Failed to execute agent diff template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
 	// this exists here instead of relying solely on /api
 	// is because htmx doesn't play well with sending json with body
 	s.HandleFunc("/agent/{agent_id}/deployments/apply", func(w http.ResponseWriter, r *http.Request) {
@@ -300,6 +390,13 @@ func (s *CentralServer) setupAgentsHTML() {
 		for _, file := range deploymentFiles {
 			log.Debugf("Applying deployment file: %s", file)
 		}
+		// namespace listed on the page, applying while every namespace is
+		// listed goes to the agent's default namespace
+		view := r.Form.Get("view")
+		namespace := view
+		if namespace == ALL_NAMESPACES {
+			namespace = ""
+		}
 
 		payload := applyDeploymentsPayload{
 			DeploymentFiles: deploymentFiles,
@@ -314,7 +411,7 @@ func (s *CentralServer) setupAgentsHTML() {
 
 		reader := bytes.NewReader(jsonBody)
 		resp, err := http.Post(
-			fmt.Sprintf(agentApplyDeploymentsEndpoint, cfg.HTTPSPort, agentid),
+			fmt.Sprintf(agentApplyDeploymentsEndpoint, cfg.HTTPSPort, agentid, url.QueryEscape(namespace)),
 			"application/json",
 			reader,
 		)
@@ -325,14 +422,14 @@ func (s *CentralServer) setupAgentsHTML() {
 		}
 
 		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			log.Error("Failed to apply deployments, status code: ", resp.StatusCode)
-			http.Error(w, "Failed to apply deployments, status code: "+resp.Status, resp.StatusCode)
+		if err := checkObjectsReport(resp); err != nil {
+			log.Error("Failed to apply deployments: ", err)
+			http.Error(w, "Failed to apply deployments: "+err.Error(), resp.StatusCode)
 			return
 		}
 
 		// Return new deployments page
-		http.Redirect(w, r, fmt.Sprintf("/agent/%s/deployments", agentid), http.StatusSeeOther)
+		http.Redirect(w, r, fmt.Sprintf("/agent/%s/deployments?namespace=%s", agentid, url.QueryEscape(view)), http.StatusSeeOther)
 	})
 
 	// this exists here instead of relying solely on /api
@@ -368,6 +465,9 @@ func (s *CentralServer) setupAgentsHTML() {
 		for _, file := range deploymentFiles {
 			log.Debugf("Removing deployment file: %s", file)
 		}
+		// namespace of the deployment and namespace listed on the page
+		namespace := r.Form.Get("namespace")
+		view := r.Form.Get("view")
 
 		payload := removeDeploymentsPayload{
 			DeploymentFiles: deploymentFiles,
@@ -382,8 +482,8 @@ func (s *CentralServer) setupAgentsHTML() {
 
 		reader := bytes.NewReader(jsonBody)
 
-		_, err = http.Post(
-			fmt.Sprintf(agentRemoveDeploymentsEndpoint, cfg.HTTPSPort, agentid),
+		resp, err := http.Post(
+			fmt.Sprintf(agentRemoveDeploymentsEndpoint, cfg.HTTPSPort, agentid, url.QueryEscape(namespace)),
 			"application/json",
 			reader,
 		)
@@ -393,8 +493,450 @@ func (s *CentralServer) setupAgentsHTML() {
 			http.Error(w, "Failed to remove deployments: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		defer resp.Body.Close()
+		if err := checkObjectsReport(resp); err != nil {
+			log.Error("Failed to remove deployments: ", err)
+			http.Error(w, "Failed to remove deployments: "+err.Error(), resp.StatusCode)
+			return
+		}
 
 		// Return new deployments page
-		http.Redirect(w, r, fmt.Sprintf("/agent/%s/deployments", agentid), http.StatusSeeOther)
+		http.Redirect(w, r, fmt.Sprintf("/agent/%s/deployments?namespace=%s", agentid, url.QueryEscape(view)), http.StatusSeeOther)
+	})
+
+	// rollout status and revisions of one deployment, loaded by each entry of
+	// the deployments partial
+	agentRolloutTempl := template.Must(template.ParseFiles("templates/agent_deployment_rollout.html"))
+	s.HandleFunc("/agent/{agent_id}/deployments/{name}/rollout", func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		agentid := vars["agent_id"]
+		name := vars["name"]
+		namespace := r.URL.Query().Get("namespace")
+
+		// GET to deployment rollout status
+		resp, err := http.Get(fmt.Sprintf(agentRolloutStatusEndpoint, cfg.HTTPSPort, agentid, name, url.QueryEscape(namespace)))
+		if err != nil {
+			log.Error("Failed to get rollout status: ", err)
+			http.Error(w, "Failed to get rollout status: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Error("Failed to get rollout status, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to get rollout status, status code: "+resp.Status, resp.StatusCode)
+			return
+		}
+		var status rolloutStatusResponse
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			log.Error("Failed to decode rollout status: ", err)
+			http.Error(w, "Failed to decode rollout status: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// GET to deployment history
+		resp, err = http.Get(fmt.Sprintf(agentHistoryEndpoint, cfg.HTTPSPort, agentid, name, url.QueryEscape(namespace)))
+		if err != nil {
+			log.Error("Failed to get deployment history: ", err)
+			http.Error(w, "Failed to get deployment history: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Error("Failed to get deployment history, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to get deployment history, status code: "+resp.Status, resp.StatusCode)
+			return
+		}
+		var history []deploymentRevision
+		if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+			log.Error("Failed to decode deployment history: ", err)
+			http.Error(w, "Failed to decode deployment history: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		htmlVars := map[string]any{
+			"AgentID":   agentid,
+			"Name":      name,
+			"Namespace": namespace,
+			"Status":    status,
+			"History":   history,
+		}
+		if err := agentRolloutTempl.Execute(w, htmlVars); err != nil {
+			log.Error("Failed to execute agent rollout template: ", err)
+			http.Error(w, "Failed to execute agent rollout template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
 	})
+
+	s.HandleFunc("/agent/{agent_id}/deployments/{name}/rollback", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Warn("Method not allowed for agent rollback endpoint")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			log.Error("Failed to parse form data: ", err)
+			http.Error(w, "Failed to parse form data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		vars := mux.Vars(r)
+		agentid := vars["agent_id"]
+		name := vars["name"]
+		namespace := r.Form.Get("namespace")
+		revision, err := strconv.ParseInt(r.Form.Get("revision"), 10, 64)
+		if err != nil {
+			log.Warn("Invalid revision for rollback: ", err)
+			http.Error(w, "Invalid revision: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		jsonBody, err := json.Marshal(rollbackPayload{Revision: revision})
+		if err != nil {
+			log.Error("Failed to marshal rollback payload: ", err)
+			http.Error(w, "Failed to marshal rollback payload: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp, err := http.Post(
+			fmt.Sprintf(agentRollbackEndpoint, cfg.HTTPSPort, agentid, name, url.QueryEscape(namespace)),
+			"application/json",
+			bytes.NewReader(jsonBody),
+		)
+		if err != nil {
+			log.Error("Failed to roll back deployment: ", err)
+			http.Error(w, "Failed to roll back deployment: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			log.Error("Failed to roll back deployment, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to roll back deployment: "+string(bodyBytes), resp.StatusCode)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/agent/%s/deployments/%s/rollout?namespace=%s", agentid, name, url.QueryEscape(namespace)), http.StatusSeeOther)
+	})
+
+	// buttons of the deployments partial, which is rendered again afterwards
+	s.HandleFunc("/agent/{agent_id}/deployments/{name}/{action:scale|restart|pause|resume}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Warn("Method not allowed for agent deployment action endpoint")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			log.Error("Failed to parse form data: ", err)
+			http.Error(w, "Failed to parse form data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		vars := mux.Vars(r)
+		agentid := vars["agent_id"]
+		name := vars["name"]
+		action := vars["action"]
+		// namespace of the deployment and namespace listed on the page
+		namespace := r.Form.Get("namespace")
+		view := r.Form.Get("view")
+
+		var body io.Reader
+		if action == "scale" {
+			replicas, err := strconv.ParseInt(r.Form.Get("replicas"), 10, 32)
+			if err != nil {
+				log.Warn("Invalid replicas for scale: ", err)
+				http.Error(w, "Invalid replicas: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			count := int32(replicas)
+			jsonBody, err := json.Marshal(scalePayload{Replicas: &count})
+			if err != nil {
+				log.Error("Failed to marshal scale payload: ", err)
+				http.Error(w, "Failed to marshal scale payload: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			body = bytes.NewReader(jsonBody)
+		}
+		resp, err := http.Post(
+			fmt.Sprintf(agentDeploymentActionEndpoint, cfg.HTTPSPort, agentid, name, action, url.QueryEscape(namespace)),
+			"application/json",
+			body,
+		)
+		if err != nil {
+			log.Errorf("Failed to %s deployment: %v", action, err)
+			http.Error(w, "Failed to "+action+" deployment: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			log.Errorf("Failed to %s deployment, status code: %d", action, resp.StatusCode)
+			http.Error(w, "Failed to "+action+" deployment: "+string(bodyBytes), resp.StatusCode)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/agent/%s/deployments?namespace=%s", agentid, url.QueryEscape(view)), http.StatusSeeOther)
+	})
+
+	agentDriftTempl := template.Must(template.ParseFiles("templates/agent_drift.html"))
+	s.HandleFunc("/agent/{agent_id}/drift", func(w http.ResponseWriter, r *http.Request) {
+		agentid := mux.Vars(r)["agent_id"]
+
+		// GET to agent drift report
+		resp, err := http.Get(fmt.Sprintf(agentDriftEndpoint, cfg.HTTPSPort, agentid))
+		if err != nil {
+			log.Error("Failed to get agent drift report: ", err)
+			http.Error(w, "Failed to get agent drift report: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Error("Failed to get agent drift report, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to get agent drift report, status code: "+resp.Status, resp.StatusCode)
+			return
+		}
+		var drift agentDriftResponse
+		if err := json.NewDecoder(resp.Body).Decode(&drift); err != nil {
+			log.Error("Failed to decode agent drift report: ", err)
+			http.Error(w, "Failed to decode agent drift report: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		selfHeal := map[string]bool{}
+		for _, file := range drift.SelfHeal {
+			selfHeal[file] = true
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		htmlVars := map[string]any{
+			"AgentID":  agentid,
+			"Report":   drift.Report,
+			"SelfHeal": selfHeal,
+		}
+		if err := agentDriftTempl.Execute(w, htmlVars); err != nil {
+			log.Error("Failed to execute agent drift template: ", err)
+			http.Error(w, "Failed to execute agent drift template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	s.HandleFunc("/agent/{agent_id}/drift/self-heal", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Warn("Method not allowed for agent self-heal endpoint")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			log.Error("Failed to parse form data: ", err)
+			http.Error(w, "Failed to parse form data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		agentid := mux.Vars(r)["agent_id"]
+		// unchecked boxes are not sent at all
+		payload := selfHealPayload{
+			File:    r.Form.Get("file"),
+			Enabled: r.Form.Get("enabled") == "on",
+		}
+		jsonBody, err := json.Marshal(payload)
+		if err != nil {
+			log.Error("Failed to marshal self-heal payload: ", err)
+			http.Error(w, "Failed to marshal self-heal payload: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp, err := http.Post(
+			fmt.Sprintf(agentSelfHealEndpoint, cfg.HTTPSPort, agentid),
+			"application/json",
+			bytes.NewReader(jsonBody),
+		)
+		if err != nil {
+			log.Error("Failed to update self-heal: ", err)
+			http.Error(w, "Failed to update self-heal: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			log.Error("Failed to update self-heal, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to update self-heal: "+string(bodyBytes), resp.StatusCode)
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/agent/%s/drift", agentid), http.StatusSeeOther)
+	})
+
+	agentReconcileTempl := template.Must(template.ParseFiles("templates/agent_reconcile.html"))
+	s.HandleFunc("/agent/{agent_id}/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		agentid := mux.Vars(r)["agent_id"]
+		endpoint := fmt.Sprintf(agentReconcileEndpoint, cfg.HTTPSPort, agentid)
+
+		var resp *http.Response
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			resp, err = http.Get(endpoint)
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				log.Error("Failed to parse form data: ", err)
+				http.Error(w, "Failed to parse form data: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if r.Form.Get("action") == "disable" {
+				var req *http.Request
+				req, err = http.NewRequest(http.MethodDelete, endpoint, nil)
+				if err != nil {
+					log.Error("Failed to create reconcile request: ", err)
+					http.Error(w, "Failed to create reconcile request: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				resp, err = http.DefaultClient.Do(req)
+				break
+			}
+			// one path per line
+			spec := ReconcileSpec{
+				Paths:     strings.Fields(r.Form.Get("paths")),
+				Namespace: r.Form.Get("namespace"),
+			}
+			var jsonBody []byte
+			jsonBody, err = json.Marshal(spec)
+			if err != nil {
+				log.Error("Failed to marshal reconcile spec: ", err)
+				http.Error(w, "Failed to marshal reconcile spec: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			resp, err = http.Post(endpoint, "application/json", bytes.NewReader(jsonBody))
+		default:
+			log.Warn("Method not allowed for agent reconcile endpoint")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			log.Error("Failed to reach agent reconcile endpoint: ", err)
+			http.Error(w, "Failed to reach agent reconcile endpoint: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			log.Error("Agent reconcile endpoint returned status code: ", resp.StatusCode)
+			http.Error(w, "Failed to handle reconcile: "+string(bodyBytes), resp.StatusCode)
+			return
+		}
+		var reconcile agentReconcileResponse
+		if err := json.NewDecoder(resp.Body).Decode(&reconcile); err != nil {
+			log.Error("Failed to decode agent reconcile state: ", err)
+			http.Error(w, "Failed to decode agent reconcile state: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		htmlVars := map[string]any{
+			"AgentID": agentid,
+			"Spec":    reconcile.Spec,
+			"Status":  reconcile.Status,
+		}
+		if err := agentReconcileTempl.Execute(w, htmlVars); err != nil {
+			log.Error("Failed to execute agent reconcile template: ", err)
+			http.Error(w, "Failed to execute agent reconcile template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	s.HandleFunc("/agent/{agent_id}/target", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Warn("Method not allowed for agent target endpoint")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			log.Error("Failed to parse form data: ", err)
+			http.Error(w, "Failed to parse form data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		agentid := mux.Vars(r)["agent_id"]
+
+		jsonBody, err := json.Marshal(agentTargetPayload{Ref: r.Form.Get("ref")})
+		if err != nil {
+			log.Error("Failed to marshal target: ", err)
+			http.Error(w, "Failed to marshal target: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp, err := http.Post(
+			fmt.Sprintf(agentTargetEndpoint, cfg.HTTPSPort, agentid),
+			"application/json",
+			bytes.NewBuffer(jsonBody),
+		)
+		if err != nil {
+			log.Error("Failed to set agent target: ", err)
+			http.Error(w, "Failed to set agent target: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			log.Error("Failed to set agent target, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to set agent target: "+string(bodyBytes), resp.StatusCode)
+			return
+		}
+
+		http.Redirect(w, r, "/agent/"+agentid, http.StatusSeeOther)
+	})
+
+	s.HandleFunc("/agent/{agent_id}/deregister", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Warn("Method not allowed for agent deregister endpoint")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			log.Error("Failed to parse form data: ", err)
+			http.Error(w, "Failed to parse form data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		agentid := mux.Vars(r)["agent_id"]
+		drain := r.Form.Get("drain") == "on"
+
+		req, err := http.NewRequest(
+			http.MethodDelete,
+			fmt.Sprintf(agentDeregisterEndpoint, cfg.HTTPSPort, agentid, drain),
+			nil,
+		)
+		if err != nil {
+			log.Error("Failed to create DELETE request: ", err)
+			http.Error(w, "Failed to create deregister request: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Error("Failed to deregister agent: ", err)
+			http.Error(w, "Failed to deregister agent: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			log.Error("Failed to deregister agent, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to deregister agent: "+string(bodyBytes), resp.StatusCode)
+			return
+		}
+
+		http.Redirect(w, r, "/agent", http.StatusSeeOther)
+	})
+}
+
+// Turns a non-200 answer of the apply/remove api into an error, listing the
+// failed objects when the body is a report.
+func checkObjectsReport(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	var report objectsReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, string(body))
+	}
+	return fmt.Errorf("%d of %d objects failed\n%s",
+		len(report.failed()),
+		len(report.Objects),
+		report.failureMessage(),
+	)
 }
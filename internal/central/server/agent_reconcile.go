@@ -0,0 +1,136 @@
+// reconcile assignments and reports of agents, see agent_reconcile_api.go
+// for the http side
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gogo/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+	. "github.com/Coosis/go-k8s-cord/internal/central/registry"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+func(s *CentralServer) GetAssignment(
+	ctx context.Context,
+	req *pbc.GetAssignmentRequest,
+) (*pbc.GetAssignmentResponse, error) {
+	id := req.GetAgentId()
+	if err := s.authorizeAgent(ctx, id); err != nil {
+		log.Warnf("Assignment request from agent %s rejected: %v", id, err)
+		return nil, err
+	}
+
+	agent, ok := s.agents.Get(id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "agent %s is not registered", id)
+	}
+	// agents fetching the repository check out their target revision, the
+	// assignment is withheld while a pinned target does not resolve
+	commit, err := s.requestedCommit(agent)
+	if err != nil {
+		log.Errorf("Withholding the assignment of agent %s: %v", id, err)
+		return nil, err
+	}
+	if agent.Reconcile == nil {
+		return &pbc.GetAssignmentResponse{
+			Enabled: proto.Bool(false),
+			Commit:  proto.String(commit),
+		}, nil
+	}
+	return &pbc.GetAssignmentResponse{
+		Enabled:   proto.Bool(true),
+		Paths:     agent.Reconcile.Paths,
+		Namespace: proto.String(agent.Reconcile.Namespace),
+		Commit:    proto.String(commit),
+	}, nil
+}
+
+func(s *CentralServer) ReportReconcile(
+	ctx context.Context,
+	req *pbc.ReportReconcileRequest,
+) (*pbc.ReportReconcileResponse, error) {
+	id := req.GetAgentId()
+	if err := s.authorizeAgent(ctx, id); err != nil {
+		log.Warnf("Reconcile report from agent %s rejected: %v", id, err)
+		return nil, err
+	}
+
+	if req.GetStatus() == RECONCILE_STATUS_FAILED {
+		log.Warnf("Reconcile failed on agent %s: %s", id, req.GetError())
+	} else {
+		log.Infof("Agent %s reconciled to %s", id, req.GetCommit())
+	}
+	_, err := s.agents.Update(ctx, id, false, func(agent *AgentMetadata) {
+		report := &ReconcileStatus{
+			Commit:    req.GetCommit(),
+			Status:    req.GetStatus(),
+			Applied:   req.GetApplied(),
+			Unchanged: req.GetUnchanged(),
+			Pruned:    req.GetPruned(),
+			Failed:    req.GetFailed(),
+			Timestamp: req.GetTimestamp(),
+		}
+		if req.GetError() != "" {
+			report.LastError = req.GetError()
+			report.LastErrorAt = req.GetTimestamp()
+		} else if agent.ReconcileStatus != nil {
+			report.LastError = agent.ReconcileStatus.LastError
+			report.LastErrorAt = agent.ReconcileStatus.LastErrorAt
+		}
+		agent.ReconcileStatus = report
+	})
+	if errors.Is(err, ErrAgentNotFound) {
+		return nil, status.Errorf(codes.NotFound, "agent %s is not registered", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the reconciled files show up in the files x agents matrix like the
+	// ones applied from the agent page
+	applied, removed := reconciledResults(req.GetObjects())
+	s.recordRemoved(ctx, id, removed)
+	s.recordApplied(ctx, id, req.GetNamespace(), req.GetCommit(), "", applied)
+
+	return &pbc.ReportReconcileResponse{
+		Success: proto.Bool(true),
+	}, nil
+}
+
+// Splits the reported objects into applied and removed ones, in the form
+// recordApplied and recordRemoved take.
+func reconciledResults(objects []*pbc.ReconciledObject) ([]*pba.ObjectResult, []*pba.ObjectResult) {
+	applied := []*pba.ObjectResult{}
+	removed := []*pba.ObjectResult{}
+	for _, object := range objects {
+		status, ok := pba.ObjectStatus_value[object.GetStatus()]
+		if !ok {
+			log.Warnf("Unknown status %q of reconciled object %s", object.GetStatus(), object.GetName())
+			continue
+		}
+		result := &pba.ObjectResult{
+			File:       proto.String(object.GetFile()),
+			ApiVersion: proto.String(object.GetApiVersion()),
+			Kind:       proto.String(object.GetKind()),
+			Namespace:  proto.String(object.GetNamespace()),
+			Name:       proto.String(object.GetName()),
+			Status:     pba.ObjectStatus(status).Enum(),
+			Error:      proto.String(object.GetError()),
+		}
+		if result.GetStatus() == pba.ObjectStatus_REMOVED {
+			removed = append(removed, result)
+		} else {
+			applied = append(applied, result)
+		}
+	}
+	return applied, removed
+}
@@ -0,0 +1,102 @@
+// per-agent reconcile assignment and status
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+
+	mux "github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+	. "github.com/Coosis/go-k8s-cord/internal/central/registry"
+)
+
+const (
+	AGENT_RECONCILE = "/api/v1/agent/{agent_id}/reconcile"
+)
+
+type agentReconcileResponse struct {
+	// nil when reconciliation is off
+	Spec   *ReconcileSpec   `json:"spec"`
+	// nil until the agent reports its first reconcile
+	Status *ReconcileStatus `json:"status"`
+}
+
+// GET returns the assignment and the latest reconcile, POST a ReconcileSpec
+// assigns paths to the agent and DELETE turns reconciliation off again.
+// The agent picks changes up with its next reconcile.
+func (s *CentralServer) agentReconcile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+
+	var agent AgentMetadata
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		var ok bool
+		agent, ok = s.agents.Get(agentID)
+		if !ok {
+			err = ErrAgentNotFound
+		}
+	case http.MethodPost:
+		var spec ReconcileSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			log.Errorf("Failed to decode reconcile spec for agent %s: %v", agentID, err)
+			http.Error(w, "Failed to decode reconcile spec: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		spec.Paths = slices.DeleteFunc(spec.Paths, func(p string) bool {
+			return p == ""
+		})
+		for _, p := range spec.Paths {
+			if !filepath.IsLocal(p) {
+				http.Error(w, p+" is outside of the deployments repository", http.StatusBadRequest)
+				return
+			}
+		}
+		slices.Sort(spec.Paths)
+		spec.Paths = slices.Compact(spec.Paths)
+		agent, err = s.agents.Update(r.Context(), agentID, false, func(agent *AgentMetadata) {
+			agent.Reconcile = &spec
+		})
+		if err == nil {
+			log.Infof("Agent %s reconciles to %v", agentID, spec.Paths)
+		}
+	case http.MethodDelete:
+		agent, err = s.agents.Update(r.Context(), agentID, false, func(agent *AgentMetadata) {
+			agent.Reconcile = nil
+		})
+		if err == nil {
+			log.Infof("Reconciliation turned off for agent %s", agentID)
+		}
+	default:
+		log.Warn("Method not allowed for agent reconcile endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if errors.Is(err, ErrAgentNotFound) {
+		log.Warnf("Agent %s not found", agentID)
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to update reconcile spec for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to update reconcile spec: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(agentReconcileResponse{
+		Spec:   agent.Reconcile,
+		Status: agent.ReconcileStatus,
+	})
+	if err != nil {
+		log.Errorf("Failed to encode reconcile state for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode reconcile state", http.StatusInternalServerError)
+		return
+	}
+}
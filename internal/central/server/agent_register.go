@@ -8,6 +8,9 @@ import (
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	. "github.com/Coosis/go-k8s-cord/internal/central/model"
 
@@ -15,10 +18,45 @@ import (
 	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
 )
 
+// Labels of an agent that are valid kubernetes labels, so label selectors
+// can match them. The others are dropped.
+func validLabels(agentID string, labels map[string]string) map[string]string {
+	valid := map[string]string{}
+	for key, value := range labels {
+		errs := validation.IsQualifiedName(key)
+		errs = append(errs, validation.IsValidLabelValue(value)...)
+		if len(errs) > 0 {
+			log.Warnf("Dropping label %s=%s of agent %s: %v", key, value, agentID, errs)
+			continue
+		}
+		valid[key] = value
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	return valid
+}
+
 func(s *CentralServer) RegisterAgent(
 	ctx context.Context,
 	req *pbc.RegisterAgentRequest,
 ) (*pbc.RegisterAgentResponse, error) {
+	// without a CSR the agent has to bring a certificate signed by the CA
+	var fingerprint string
+	var err error
+	if len(req.GetCsr()) == 0 {
+		fingerprint, err = peerFingerprint(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else if s.ca == nil {
+		return nil, status.Error(codes.FailedPrecondition, "central cannot sign certificates, ca.key is missing")
+	}
+	if err := s.agents.ConsumeToken(ctx, req.GetBootstrapToken()); err != nil {
+		log.Warn("Agent registration rejected: ", err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
 	id, err := uuid.NewV7()
 	if err != nil {
 		log.Error("Failed to generate UUID:", err)
@@ -30,15 +68,38 @@ func(s *CentralServer) RegisterAgent(
 		id,
 		req.GetAgentGrpcEndpoint(),
 	)
-	s.agents[idstr] = &AgentMetadata{
-		Name: "TO_BE_SET", // Placeholder, should be set from heartbeat request
-		GrpcEndpoint: req.GetAgentGrpcEndpoint(),
+	var certPEM, caPEM []byte
+	if len(req.GetCsr()) != 0 {
+		certPEM, fingerprint, err = s.issueAgentCert(req.GetCsr(), idstr, req.GetAgentGrpcEndpoint())
+		if err != nil {
+			log.Error("Failed to issue agent certificate:", err)
+			return nil, err
+		}
+		caPEM = s.ca.CertificatePEM()
+	}
+	name := req.GetAgentName()
+	if name == "" {
+		name = "TO_BE_SET" // Placeholder, should be set from heartbeat request
 	}
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
+	_, err = s.agents.Update(ctx, idstr, true, func(agent *AgentMetadata) {
+		agent.Name = name
+		agent.GrpcEndpoint = req.GetAgentGrpcEndpoint()
+		agent.RegisteredAt = time.Now().Unix()
+		agent.Version = req.GetAgentVersion()
+		agent.CertFingerprint = fingerprint
+		agent.Labels = validLabels(idstr, req.GetLabels())
+	})
+	if err != nil {
+		log.Error("Failed to persist agent registration:", err)
+		return nil, err
+	}
 	return &pbc.RegisterAgentResponse{
 		Success: proto.Bool(true),
 		Id: &idstr,
 		Timestamp: proto.Int64(time.Now().Unix()),
+		Certificate: certPEM,
+		CaCertificate: caPEM,
 	}, nil
 }
@@ -0,0 +1,197 @@
+// revision of the deployments repository each agent follows
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	mux "github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/deployment"
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+	. "github.com/Coosis/go-k8s-cord/internal/central/registry"
+)
+
+const (
+	AGENT_TARGET = "/api/v1/agent/{agent_id}/target"
+	// same for every agent of a group, see fleet_api.go
+	FLEET_TARGET = "/api/v1/fleet/target"
+)
+
+type agentTargetPayload struct {
+	// branch, tag or commit, empty to follow central's HEAD again
+	Ref string `json:"ref"`
+}
+
+type agentTargetResponse struct {
+	Ref    string `json:"ref"`
+	Commit string `json:"commit"`
+}
+
+// Commit the agent's target ref currently points to.
+func (s *CentralServer) targetCommit(agentID string) (string, error) {
+	agent, ok := s.agents.Get(agentID)
+	if !ok {
+		return "", ErrAgentNotFound
+	}
+	commit, err := ResolveRef(s.repo, agent.TargetRef)
+	if err != nil {
+		return "", fmt.Errorf("target of agent %s: %w", agentID, err)
+	}
+	return commit, nil
+}
+
+// Commit an agent fetching the repository on its own checks out before
+// reading files. A pinned target that does not resolve(e.g. a deleted tag)
+// is an error, the agent must not move off it. Empty, the tip of the agent's
+// branch, only when it has no target and central's HEAD does not resolve.
+func (s *CentralServer) requestedCommit(agent AgentMetadata) (string, error) {
+	commit, err := ResolveRef(s.repo, agent.TargetRef)
+	if err == nil {
+		return commit, nil
+	}
+	if agent.TargetRef != "" {
+		return "", status.Errorf(codes.FailedPrecondition, "target %s of agent %s does not resolve: %v", agent.TargetRef, agent.ID, err)
+	}
+	log.Errorf("Failed to resolve the deployments HEAD for agent %s: %v", agent.ID, err)
+	return "", nil
+}
+
+// Same as requestedCommit, by agent ID.
+func (s *CentralServer) requestedCommitOf(agentID string) (string, error) {
+	agent, ok := s.agents.Get(agentID)
+	if !ok {
+		return "", fmt.Errorf("agent %s: %w", agentID, ErrAgentNotFound)
+	}
+	return s.requestedCommit(agent)
+}
+
+// GET returns the agent's target ref and the commit it resolves to, POST an
+// agentTargetPayload sets it. Refs that do not resolve are rejected.
+func (s *CentralServer) agentTarget(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	agentID := vars["agent_id"]
+
+	var agent AgentMetadata
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		var ok bool
+		agent, ok = s.agents.Get(agentID)
+		if !ok {
+			err = ErrAgentNotFound
+		}
+	case http.MethodPost:
+		var payload agentTargetPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			log.Errorf("Failed to decode target for agent %s: %v", agentID, err)
+			http.Error(w, "Failed to decode target: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ref := strings.TrimSpace(payload.Ref)
+		if _, err := ResolveRef(s.repo, ref); err != nil {
+			log.Warnf("Rejected target %q for agent %s: %v", ref, agentID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		agent, err = s.agents.Update(r.Context(), agentID, false, func(agent *AgentMetadata) {
+			agent.TargetRef = ref
+		})
+		if err == nil {
+			log.Infof("Agent %s targets %q", agentID, ref)
+		}
+	default:
+		log.Warn("Method not allowed for agent target endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if errors.Is(err, ErrAgentNotFound) {
+		log.Warnf("Agent %s not found", agentID)
+		http.Error(w, "Agent not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to update target for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to update target: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	commit, err := ResolveRef(s.repo, agent.TargetRef)
+	if err != nil {
+		// e.g. a branch deleted since it was set
+		log.Warnf("Target of agent %s does not resolve: %v", agentID, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(agentTargetResponse{
+		Ref:    agent.TargetRef,
+		Commit: commit,
+	})
+	if err != nil {
+		log.Errorf("Failed to encode target for agent %s: %v", agentID, err)
+		http.Error(w, "Failed to encode target", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Target of every selected agent. POST sets the agentTargetPayload on all of
+// them, e.g. ?selector=env=prod to pin the production clusters to a tag.
+func (s *CentralServer) fleetTarget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		log.Warn("Method not allowed for fleet target endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agents, _, ok := s.fleetRequest(w, r)
+	if !ok {
+		return
+	}
+	set := r.Method == http.MethodPost
+	var ref string
+	if set {
+		var payload agentTargetPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			log.Error("Failed to decode fleet target: ", err)
+			http.Error(w, "Failed to decode target: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ref = strings.TrimSpace(payload.Ref)
+		if _, err := ResolveRef(s.repo, ref); err != nil {
+			log.Warnf("Rejected fleet target %q: %v", ref, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	results := make(map[string]fleetResult, len(agents))
+	for _, agent := range agents {
+		var err error
+		if set {
+			agent, err = s.agents.Update(r.Context(), agent.ID, false, func(agent *AgentMetadata) {
+				agent.TargetRef = ref
+			})
+			if err == nil {
+				log.Infof("Agent %s targets %q", agent.ID, ref)
+			}
+		}
+		result := fleetResult{Name: agent.Name}
+		if err != nil {
+			result.Error = err.Error()
+			results[agent.ID] = result
+			continue
+		}
+		target := agentTargetResponse{Ref: agent.TargetRef}
+		target.Commit, err = ResolveRef(s.repo, agent.TargetRef)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Result = target
+		results[agent.ID] = result
+	}
+	writeFleetResults(w, results)
+}
@@ -0,0 +1,40 @@
+// agent tunnel grpc server-side implementation, agents behind NAT keep this
+// stream open and central routes its AgentService calls through it.
+package server
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/registry"
+
+	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
+)
+
+func(s *CentralServer) Connect(stream pbc.CentralService_ConnectServer) error {
+	// the first message identifies the agent
+	hello, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	id := hello.GetAgentId()
+	if id == "" {
+		return status.Error(codes.InvalidArgument, "first tunnel message must carry the agent ID")
+	}
+	if err := s.authorizeAgent(stream.Context(), id); err != nil {
+		log.Warnf("Tunnel from agent %s rejected: %v", id, err)
+		return err
+	}
+
+	tunnel := NewTunnel(stream)
+	if err := s.agents.AttachTunnel(id, tunnel); err != nil {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	defer s.agents.DetachTunnel(id, tunnel)
+
+	err = tunnel.Serve()
+	log.Debugf("Tunnel to agent %s closed: %v", id, err)
+	return nil
+}
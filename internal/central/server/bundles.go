@@ -0,0 +1,73 @@
+// bundles of deployment files shipped to agents, see ship_bundles in the
+// central config
+package server
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	. "github.com/Coosis/go-k8s-cord/internal"
+	. "github.com/Coosis/go-k8s-cord/internal/central/deployment"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+// Packs the files at the given commit, directories are expanded.
+func (s *CentralServer) newBundle(commit string, paths []string) (*pba.Bundle, error) {
+	files, err := FilesAt(s.repo, commit, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &pba.Bundle{
+		Hash:   proto.String(BundleHash(files)),
+		Commit: proto.String(commit),
+	}
+	for _, path := range slices.Sorted(maps.Keys(files)) {
+		bundle.Files = append(bundle.Files, &pba.BundleFile{
+			Path:    proto.String(path),
+			Content: files[path],
+		})
+	}
+	return bundle, nil
+}
+
+// Makes the call with the hash of the bundle first, and with the whole
+// bundle only if the agent does not have it cached yet, along with its hash.
+// The files are taken at the agent's target revision, the commit is passed
+// with both as the same files may be cached from another commit.
+func (s *CentralServer) withBundle(
+	agentID string,
+	paths []string,
+	call func(bundle *pba.Bundle, hash string, commit string) error,
+) error {
+	commit, err := s.targetCommit(agentID)
+	if err != nil {
+		return err
+	}
+	return s.withBundleAt(commit, paths, call)
+}
+
+// Same as withBundle with the files at the given commit.
+func (s *CentralServer) withBundleAt(
+	commit string,
+	paths []string,
+	call func(bundle *pba.Bundle, hash string, commit string) error,
+) error {
+	bundle, err := s.newBundle(commit, paths)
+	if err != nil {
+		return fmt.Errorf("failed to bundle %v: %w", paths, err)
+	}
+	err = call(nil, bundle.GetHash(), commit)
+	if status.Code(err) != codes.FailedPrecondition {
+		return err
+	}
+	log.Debugf("Agent does not have bundle %s, sending %d files", bundle.GetHash(), len(bundle.GetFiles()))
+	return call(bundle, bundle.GetHash(), commit)
+}
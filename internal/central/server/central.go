@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -18,8 +19,11 @@ import (
 	mux "github.com/gorilla/mux"
 
 	. "github.com/Coosis/go-k8s-cord/internal"
+	. "github.com/Coosis/go-k8s-cord/internal/central/ca"
 	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+	. "github.com/Coosis/go-k8s-cord/internal/central/registry"
 
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
 	pbc "github.com/Coosis/go-k8s-cord/internal/pb/central/v1"
 )
 
@@ -29,11 +33,18 @@ type CentralServer struct {
 	etcd *clientv3.Client
 
 	tlsConfig *tls.Config
+	certs *CertReloader
+	// signs agent certificates, nil if ca.key is not available
+	ca *CA
 
-	agents map[string]*AgentMetadata
+	agents *Registry
 
 	repo *gogit.Repository
 
+	// cancels the runners of the running rollouts, see rollout.go
+	runnersMu sync.Mutex
+	runners map[string]context.CancelFunc
+
 	pbc.UnimplementedCentralServiceServer
 }
 
@@ -67,17 +78,30 @@ func NewCentralServer(
 
 	cfg := GetCentralConfig()
 
-	cert, err := tls.LoadX509KeyPair("server.crt", "server.key")
+	certs, err := NewCertReloader("server.crt", "server.key")
 	if err != nil {
 		err := fmt.Errorf("Failed to load server certificate and key: %v", err)
 		return nil, err
 	}
+	if certs.Certificate() == nil {
+		return nil, fmt.Errorf("Failed to load server certificate and key: server.crt not found")
+	}
+	// agents without a certificate yet are let through the handshake so they
+	// can enroll with a bootstrap token, every other call checks the peer
+	// certificate against the registry(see agent_auth.go)
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS13,
 		ClientCAs: cas,
 		RootCAs: cas,
-		ClientAuth: tls.RequireAndVerifyClientCert,
-		Certificates: []tls.Certificate{cert},
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		GetCertificate: certs.GetCertificate,
+		GetClientCertificate: certs.GetClientCertificate,
+	}
+
+	authority, err := LoadCA("ca.crt", "ca.key")
+	if err != nil {
+		log.Warn("CA key not available, agents have to bring their own certificates: ", err)
+		authority = nil
 	}
 
 	grpcCreds := credentials.NewTLS(tlsConfig)
@@ -103,9 +127,20 @@ func NewCentralServer(
 		gs: grpcServer,
 		etcd: etcd_client,
 		tlsConfig: tlsConfig,
-		agents: make(map[string]*AgentMetadata),
+		certs: certs,
+		ca: authority,
+		agents: NewRegistry(etcd_client, tlsConfig),
+		runners: map[string]context.CancelFunc{},
 	}
 	cs.GitInit()
+
+	loadCtx, loadCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer loadCancel()
+	if err := cs.agents.Load(loadCtx); err != nil {
+		log.Error("Failed to load agent registry:", err)
+		return nil, err
+	}
+
 	pbc.RegisterCentralServiceServer(grpcServer, cs)
 
 	return cs, nil
@@ -129,19 +164,9 @@ func(s *CentralServer) Start(ctx context.Context) error {
 			log.Info("Http server shutdown successfully")
 		}
 
+		s.stopRollouts()
 		s.gs.GracefulStop()
-		for id, agent := range s.agents {
-			if agent.AgentConn != nil {
-				log.Debugf("Closing gRPC connection for agent %s", id)
-				if err := agent.AgentConn.Close(); err != nil {
-					log.Error("Failed to close gRPC connection for agent ", id, ": ", err)
-				} else {
-					log.Debugf("gRPC connection for agent %s closed successfully", id)
-				}
-			} else {
-				log.Debugf("No gRPC connection to close for agent %s", id)
-			}
-		}
+		s.agents.Close()
 		log.Info("gRPC server shutdown successfully")
 	}()
 
@@ -157,11 +182,18 @@ func(s *CentralServer) Start(ctx context.Context) error {
 		s.setupDeploymentRoutes()
 		s.setupStatusRoutes()
 		s.setupAgentRoutes()
+		s.setupAgentDeploymentRoutes()
+		s.setupTokenRoutes()
+		s.setupMatrixRoutes()
+		s.setupRolloutRoutes()
+		s.setupFleetRoutes()
 
 		s.setupRootHTML()
 		s.setupStatusHTML()
 		s.setupAgentsHTML()
 		s.setupDeploymentsHTML()
+		s.setupMatrixHTML()
+		s.setupRolloutsHTML()
 
 		log.Infof("Visit http://localhost%s to access the central ui", s.s.Addr)
 
@@ -174,6 +206,10 @@ func(s *CentralServer) Start(ctx context.Context) error {
 		}
 		return nil
 	})
+	g.Go(func() error {
+		return s.agents.WatchLiveness(ctx)
+	})
+	s.resumeRollouts(ctx)
 	g.Go(func() error {
 		lis, err := net.Listen("tcp", cfg.GRPCPort)
 		if err != nil {
@@ -217,3 +253,11 @@ func(s *CentralServer) HandleFunc(
 ) {
 	s.s.Handler.(*mux.Router).HandleFunc(pat, f)
 }
+
+func (s *CentralServer) agentClient(agentID string) (pba.AgentServiceClient, error) {
+	conn, err := s.agents.Conn(agentID)
+	if err != nil {
+		return nil, err
+	}
+	return pba.NewAgentServiceClient(conn), nil
+}
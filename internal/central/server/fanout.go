@@ -0,0 +1,131 @@
+// fan-out of agent calls, used by the fleet api and the status api
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+// Outcome of a call on one agent, Error is set when it failed.
+type fleetResult struct {
+	Name     string `json:"name"`
+	Result   any    `json:"result,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// how long the agent took to answer
+	Duration int64  `json:"duration_ms"`
+}
+
+type fanOutOptions struct {
+	// agents called at the same time
+	Concurrency int
+	// each agent has this long to answer
+	Timeout     time.Duration
+}
+
+type fanOutFunc func(ctx context.Context, client pba.AgentServiceClient, agent AgentMetadata) (any, error)
+
+// Options from the central config, overridden by the ?concurrency= and
+// ?timeout= (seconds) query parameters.
+func fanOutOptionsFrom(r *http.Request) (fanOutOptions, error) {
+	cfg := GetCentralConfig()
+	opts := fanOutOptions{
+		Concurrency: cfg.FleetConcurrency,
+		Timeout:     time.Duration(cfg.FleetTimeout) * time.Second,
+	}
+	if value := r.URL.Query().Get("concurrency"); value != "" {
+		concurrency, err := strconv.Atoi(value)
+		if err != nil || concurrency <= 0 {
+			return opts, fmt.Errorf("invalid concurrency %q", value)
+		}
+		opts.Concurrency = concurrency
+	}
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			return opts, fmt.Errorf("invalid timeout %q", value)
+		}
+		opts.Timeout = time.Duration(seconds) * time.Second
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DEFAULT_FLEET_CONCURRENCY
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DEFAULT_FLEET_TIMEOUT * time.Second
+	}
+	return opts, nil
+}
+
+// Calls fn on every agent, at most opts.Concurrency at a time and each
+// within opts.Timeout. Once ctx is cancelled the running calls are cancelled
+// and the agents still waiting report the cancellation. Results are keyed by
+// agent ID.
+func (s *CentralServer) fanOut(
+	ctx context.Context,
+	agents []AgentMetadata,
+	opts fanOutOptions,
+	fn fanOutFunc,
+) map[string]fleetResult {
+	results := make(map[string]fleetResult, len(agents))
+	slots := make(chan struct{}, max(opts.Concurrency, 1))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, agent := range agents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := fleetResult{Name: agent.Name}
+			var err error
+			select {
+			case slots <- struct{}{}:
+				start := time.Now()
+				result.Result, err = s.callAgent(ctx, agent, opts.Timeout, fn)
+				result.Duration = time.Since(start).Milliseconds()
+				<-slots
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err != nil {
+				log.Errorf("Call on agent %s failed: %v", agent.ID, err)
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			results[agent.ID] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (s *CentralServer) callAgent(
+	ctx context.Context,
+	agent AgentMetadata,
+	timeout time.Duration,
+	fn fanOutFunc,
+) (any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	client, err := s.agentClient(agent.ID)
+	if err != nil {
+		return nil, err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result, err := fn(callCtx, client, agent)
+	if err != nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return result, fmt.Errorf("no answer within %s: %w", timeout, err)
+	}
+	return result, err
+}
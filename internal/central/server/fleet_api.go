@@ -0,0 +1,284 @@
+// fleet-wide api, the same calls as the per-agent api on every agent
+// matching a label selector, e.g. ?selector=env=prod,region!=eu, or on the
+// agents listed with ?agent=. Calls go through the fan-out in "fanout.go",
+// ?concurrency= and ?timeout= override the configured limits.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+const (
+	FLEET_AGENTS             = "/api/v1/fleet/agents"
+	FLEET_PODS               = "/api/v1/fleet/pods"
+	FLEET_DEPLOYMENTS        = "/api/v1/fleet/deployments"
+	FLEET_DEPLOYMENTS_APPLY  = "/api/v1/fleet/deployments/apply"
+	FLEET_DEPLOYMENTS_REMOVE = "/api/v1/fleet/deployments/remove"
+)
+
+type fleetAgent struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Online bool              `json:"online"`
+}
+
+func (s *CentralServer) setupFleetRoutes() {
+	s.HandleFunc(FLEET_AGENTS, s.fleetAgents)
+	s.HandleFunc(FLEET_PODS, s.fleetPods)
+	s.HandleFunc(FLEET_DEPLOYMENTS, s.fleetDeployments)
+	s.HandleFunc(FLEET_DEPLOYMENTS_APPLY, s.fleetApplyDeployments)
+	s.HandleFunc(FLEET_DEPLOYMENTS_REMOVE, s.fleetRemoveDeployments)
+	s.HandleFunc(FLEET_TARGET, s.fleetTarget)
+}
+
+// Agents listed with ?agent=, otherwise the agents matching the ?selector=
+// query parameter, every agent when both are empty. Listed agents that are
+// not registered are kept, their calls fail with the reason.
+func (s *CentralServer) selectedAgents(r *http.Request) ([]AgentMetadata, error) {
+	query := r.URL.Query()
+	if ids := query["agent"]; len(ids) > 0 {
+		if query.Get("selector") != "" {
+			return nil, fmt.Errorf("agent and selector are mutually exclusive")
+		}
+		agents := []AgentMetadata{}
+		seen := map[string]bool{}
+		for _, id := range ids {
+			if id == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			agent, ok := s.agents.Get(id)
+			if !ok {
+				agent = AgentMetadata{ID: id}
+			}
+			agents = append(agents, agent)
+		}
+		return agents, nil
+	}
+	selector, err := labels.Parse(query.Get("selector"))
+	if err != nil {
+		return nil, err
+	}
+	return s.agents.Select(selector), nil
+}
+
+// Selected agents and fan-out options of a fleet request, writes the 400
+// and returns false when either is invalid. Requests changing the agents
+// (anything but GET) have to select them explicitly, a missing selector
+// does not fall back to every agent.
+func (s *CentralServer) fleetRequest(w http.ResponseWriter, r *http.Request) ([]AgentMetadata, fanOutOptions, bool) {
+	query := r.URL.Query()
+	if r.Method != http.MethodGet && query.Get("selector") == "" && len(query["agent"]) == 0 {
+		log.Warn("Rejected fleet request without selector or agents: ", r.URL.Path)
+		http.Error(w, "A selector or a list of agents is required", http.StatusBadRequest)
+		return nil, fanOutOptions{}, false
+	}
+	agents, err := s.selectedAgents(r)
+	if err != nil {
+		log.Warn("Invalid agent selection: ", err)
+		http.Error(w, "Invalid selection: "+err.Error(), http.StatusBadRequest)
+		return nil, fanOutOptions{}, false
+	}
+	opts, err := fanOutOptionsFrom(r)
+	if err != nil {
+		log.Warn("Invalid fan-out options: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, fanOutOptions{}, false
+	}
+	return agents, opts, true
+}
+
+// 200 when the call went through on every agent, 207 otherwise.
+func writeFleetResults(w http.ResponseWriter, results map[string]fleetResult) {
+	code := http.StatusOK
+	for _, result := range results {
+		if result.Error != "" {
+			code = http.StatusMultiStatus
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Error("Failed to encode fleet results: ", err)
+	}
+}
+
+func (s *CentralServer) fleetAgents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn("Method not allowed for fleet agents endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agents, err := s.selectedAgents(r)
+	if err != nil {
+		log.Warn("Invalid agent selection: ", err)
+		http.Error(w, "Invalid selection: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fleet := []fleetAgent{}
+	for _, agent := range agents {
+		fleet = append(fleet, fleetAgent{
+			ID:     agent.ID,
+			Name:   agent.Name,
+			Labels: agent.Labels,
+			Online: agent.Online,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(fleet); err != nil {
+		log.Error("Failed to encode fleet agents: ", err)
+		http.Error(w, "Failed to encode fleet agents", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Pods of every selected agent, ?namespace= works as for a single agent.
+func (s *CentralServer) fleetPods(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn("Method not allowed for fleet pods endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agents, opts, ok := s.fleetRequest(w, r)
+	if !ok {
+		return
+	}
+	namespace, all := namespaceQuery(r)
+
+	results := s.fanOut(r.Context(), agents, opts, func(
+		ctx context.Context,
+		client pba.AgentServiceClient,
+		agent AgentMetadata,
+	) (any, error) {
+		resp, err := client.ListPods(ctx, &pba.ListPodsRequest{
+			Namespace:     proto.String(namespace),
+			AllNamespaces: proto.Bool(all),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.GetPods(), nil
+	})
+	writeFleetResults(w, results)
+}
+
+// Deployments of every selected agent, ?namespace= works as for a single
+// agent.
+func (s *CentralServer) fleetDeployments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn("Method not allowed for fleet deployments endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agents, opts, ok := s.fleetRequest(w, r)
+	if !ok {
+		return
+	}
+	namespace, all := namespaceQuery(r)
+
+	results := s.fanOut(r.Context(), agents, opts, func(
+		ctx context.Context,
+		client pba.AgentServiceClient,
+		agent AgentMetadata,
+	) (any, error) {
+		resp, err := client.ListDeployments(ctx, &pba.ListDeploymentsRequest{
+			Namespace:     proto.String(namespace),
+			AllNamespaces: proto.Bool(all),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.GetDeployments(), nil
+	})
+	writeFleetResults(w, results)
+}
+
+// Applies the same payload as the per-agent apply on every selected agent,
+// each result is the agent's objects report.
+func (s *CentralServer) fleetApplyDeployments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn("Method not allowed for fleet apply deployments endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agents, opts, ok := s.fleetRequest(w, r)
+	if !ok {
+		return
+	}
+	var payload applyDeploymentsPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		log.Error("Failed to decode deployment files: ", err)
+		http.Error(w, "Failed to decode deployment files: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+
+	results := s.fanOut(r.Context(), agents, opts, func(
+		ctx context.Context,
+		client pba.AgentServiceClient,
+		agent AgentMetadata,
+	) (any, error) {
+		resp, err := s.applyDeployments(ctx, client, agent.ID, payload.DeploymentFiles, namespace)
+		if err != nil {
+			return nil, err
+		}
+		return reportResult(newObjectsReport(resp.GetSuccess(), resp.GetObjects()))
+	})
+	writeFleetResults(w, results)
+}
+
+// Same payload as the per-agent remove.
+func (s *CentralServer) fleetRemoveDeployments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn("Method not allowed for fleet remove deployments endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agents, opts, ok := s.fleetRequest(w, r)
+	if !ok {
+		return
+	}
+	var payload removeDeploymentsPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		log.Error("Failed to decode deployment files: ", err)
+		http.Error(w, "Failed to decode deployment files: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+
+	results := s.fanOut(r.Context(), agents, opts, func(
+		ctx context.Context,
+		client pba.AgentServiceClient,
+		agent AgentMetadata,
+	) (any, error) {
+		resp, err := s.removeDeployments(ctx, client, agent.ID, payload.DeploymentFiles, namespace)
+		if err != nil {
+			return nil, err
+		}
+		return reportResult(newObjectsReport(resp.GetSuccess(), resp.GetObjects()))
+	})
+	writeFleetResults(w, results)
+}
+
+// The report as a fleet result, failing when any object failed.
+func reportResult(report objectsReport) (any, error) {
+	if report.statusCode() != http.StatusOK {
+		return report, fmt.Errorf("%d of %d objects failed: %s",
+			len(report.failed()), len(report.Objects), strings.TrimSpace(report.failureMessage()))
+	}
+	return report, nil
+}
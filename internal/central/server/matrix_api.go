@@ -0,0 +1,138 @@
+// files x agents matrix of the deployment files, look for "matrix_page.go"
+// for the htmx integration.
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/deployment"
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+)
+
+const (
+	DEPLOYMENTS_MATRIX_PATH = "/api/v1/deployments/matrix"
+)
+
+type matrixAgent struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	// commit the agent's files are compared against
+	Target string `json:"target"`
+}
+
+type matrixResponse struct {
+	// HEAD of the deployments repository
+	Commit string        `json:"commit"`
+	Files  []string      `json:"files"`
+	Agents []matrixAgent `json:"agents"`
+	// file -> agent ID -> status, agents without the file are left out
+	Status map[string]map[string]string `json:"status"`
+}
+
+func (s *CentralServer) setupMatrixRoutes() {
+	s.HandleFunc(DEPLOYMENTS_MATRIX_PATH, s.deploymentsMatrix)
+}
+
+func (s *CentralServer) deploymentsMatrix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn("Method not allowed for deployments matrix endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	head, err := DeploymentsHash(s.repo)
+	if err != nil {
+		log.Error("Failed to get deployments hash: ", err)
+		http.Error(w, "Failed to get deployments hash: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	files, err := ListDeploymentFiles(s.repo)
+	if err != nil {
+		log.Error("Failed to list deployments: ", err)
+		http.Error(w, "Failed to list deployments: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	desired, err := s.agents.AllDesired(r.Context())
+	if err != nil {
+		log.Error("Failed to load desired state: ", err)
+		http.Error(w, "Failed to load desired state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	matrix := matrixResponse{
+		Commit: head,
+		Files:  files,
+		Agents: []matrixAgent{},
+		Status: map[string]map[string]string{},
+	}
+	agents := s.agents.List()
+	slices.SortFunc(agents, func(a, b AgentMetadata) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	})
+	for _, agent := range agents {
+		target, err := ResolveRef(s.repo, agent.TargetRef)
+		if err != nil {
+			log.Warnf("Target of agent %s does not resolve: %v", agent.ID, err)
+		}
+		matrix.Agents = append(matrix.Agents, matrixAgent{ID: agent.ID, Name: agent.Name, Target: target})
+		for name, file := range desired[agent.ID].Files {
+			if _, ok := matrix.Status[name]; !ok {
+				matrix.Status[name] = map[string]string{}
+			}
+			matrix.Status[name][agent.ID] = s.fileStatus(agent, name, file, target)
+			if !slices.Contains(matrix.Files, name) {
+				matrix.Files = append(matrix.Files, name)
+			}
+		}
+	}
+	slices.Sort(matrix.Files)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(matrix)
+	if err != nil {
+		log.Error("Failed to encode deployments matrix: ", err)
+		http.Error(w, "Failed to encode deployments matrix", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Status of a recorded file on an agent compared to the agent's target
+// commit, see FILE_STATUS_* in the model.
+func (s *CentralServer) fileStatus(
+	agent AgentMetadata,
+	name string,
+	file DesiredFile,
+	target string,
+) string {
+	if target == "" {
+		return FILE_STATUS_OUTDATED
+	}
+	targetBlob, err := FileHashAt(s.repo, target, name)
+	if err != nil {
+		log.Errorf("Failed to hash %s at %s: %v", name, target, err)
+		return FILE_STATUS_OUTDATED
+	}
+	if targetBlob == "" {
+		return FILE_STATUS_MISSING
+	}
+	if file.Commit == "" {
+		return FILE_STATUS_OUTDATED
+	}
+	blob, err := FileHashAt(s.repo, file.Commit, name)
+	if err != nil {
+		log.Debugf("Commit %s of %s on agent %s is unknown: %v", file.Commit, name, agent.ID, err)
+		return FILE_STATUS_OUTDATED
+	}
+	if blob != targetBlob {
+		return FILE_STATUS_OUTDATED
+	}
+	if agent.Drift == nil || !slices.Contains(agent.Drift.CheckedFiles, name) {
+		return FILE_STATUS_PENDING
+	}
+	return FILE_STATUS_APPLIED
+}
@@ -0,0 +1,55 @@
+// files x agents matrix page, look for "matrix_api.go" for the API implementation.
+// For html look for matrix.html in templates directory.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DEPLOYMENTS_MATRIX_URL = "http://localhost%s" + DEPLOYMENTS_MATRIX_PATH
+
+	PAGE_MATRIX_PATH = "/matrix"
+)
+
+func(s *CentralServer) setupMatrixHTML() {
+	cfg := GetCentralConfig()
+	matrixTemplate := template.Must(template.ParseFiles(
+		"templates/base.html",
+		"templates/matrix.html",
+	))
+	s.HandleFunc(PAGE_MATRIX_PATH, func(w http.ResponseWriter, r *http.Request) {
+		resp, err := http.Get(fmt.Sprintf(DEPLOYMENTS_MATRIX_URL, cfg.HTTPSPort))
+		if err != nil {
+			log.Error("Failed to get deployments matrix: ", err)
+			http.Error(w, "Failed to get deployments matrix: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Error("Failed to get deployments matrix, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to get deployments matrix, status code: "+resp.Status, resp.StatusCode)
+			return
+		}
+		var matrix matrixResponse
+		if err := json.NewDecoder(resp.Body).Decode(&matrix); err != nil {
+			log.Error("Failed to decode deployments matrix: ", err)
+			http.Error(w, "Failed to decode deployments matrix: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		if err := matrixTemplate.Execute(w, matrix); err != nil {
+			log.Error("Failed to execute matrix template: ", err)
+			http.Error(w, "Failed to execute matrix template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
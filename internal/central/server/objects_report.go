@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+// JSON report of an apply, diff or remove, one entry per object.
+type objectsReport struct {
+	Success bool           `json:"success"`
+	Objects []objectReport `json:"objects"`
+}
+
+type objectReport struct {
+	File            string `json:"file,omitempty"`
+	APIVersion      string `json:"apiVersion,omitempty"`
+	Kind            string `json:"kind,omitempty"`
+	Namespace       string `json:"namespace,omitempty"`
+	Name            string `json:"name,omitempty"`
+	// applied, unchanged, failed or removed
+	Status          string `json:"status"`
+	Error           string `json:"error,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	Diff            string `json:"diff,omitempty"`
+}
+
+func newObjectsReport(success bool, objects []*pba.ObjectResult) objectsReport {
+	report := objectsReport{
+		Success: success,
+		Objects: []objectReport{},
+	}
+	for _, obj := range objects {
+		report.Objects = append(report.Objects, objectReport{
+			File:            obj.GetFile(),
+			APIVersion:      obj.GetApiVersion(),
+			Kind:            obj.GetKind(),
+			Namespace:       obj.GetNamespace(),
+			Name:            obj.GetName(),
+			Status:          strings.ToLower(obj.GetStatus().String()),
+			Error:           obj.GetError(),
+			ResourceVersion: obj.GetResourceVersion(),
+			Diff:            obj.GetDiff(),
+		})
+	}
+	return report
+}
+
+func (r objectsReport) failed() []objectReport {
+	failed := []objectReport{}
+	for _, obj := range r.Objects {
+		if obj.Status == "failed" {
+			failed = append(failed, obj)
+		}
+	}
+	return failed
+}
+
+// 200 when every object went through, 207 on a partial failure and 422
+// when nothing did.
+func (r objectsReport) statusCode() int {
+	failed := len(r.failed())
+	switch {
+	case r.Success && failed == 0:
+		return http.StatusOK
+	case failed < len(r.Objects):
+		return http.StatusMultiStatus
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+// One line per failed object, for the html pages.
+func (r objectsReport) failureMessage() string {
+	var b strings.Builder
+	for _, obj := range r.failed() {
+		b.WriteString(obj.File)
+		if obj.Name != "" {
+			if obj.File != "" {
+				b.WriteString(": ")
+			}
+			b.WriteString(obj.Kind + " ")
+			if obj.Namespace != "" {
+				b.WriteString(obj.Namespace + "/")
+			}
+			b.WriteString(obj.Name)
+		}
+		b.WriteString(": " + obj.Error + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,618 @@
+// progressive rollouts of deployment files across agents, look for
+// "rollout_api.go" for the http side.
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/deployment"
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+const (
+	// seconds between two health checks of a wave
+	ROLLOUT_HEALTH_INTERVAL = 5
+)
+
+// Agent IDs of each wave, in order. Sizes are counts or percentages of all
+// agents, the agents left after the last size make up one more wave.
+func planWaves(agents []string, sizes []string) ([][]string, error) {
+	waves := [][]string{}
+	rest := agents
+	for _, size := range sizes {
+		var n int
+		if p, ok := strings.CutSuffix(size, "%"); ok {
+			percent, err := strconv.ParseFloat(p, 64)
+			if err != nil || percent <= 0 || percent > 100 {
+				return nil, fmt.Errorf("invalid wave size %q", size)
+			}
+			n = int(math.Ceil(float64(len(agents)) * percent / 100))
+		} else {
+			count, err := strconv.Atoi(size)
+			if err != nil || count <= 0 {
+				return nil, fmt.Errorf("invalid wave size %q", size)
+			}
+			n = count
+		}
+		n = min(n, len(rest))
+		if n > 0 {
+			waves = append(waves, slices.Clone(rest[:n]))
+			rest = rest[n:]
+		}
+	}
+	if len(rest) > 0 {
+		waves = append(waves, slices.Clone(rest))
+	}
+	return waves, nil
+}
+
+// Validates the spec and resolves its agents and waves. The files are
+// pinned to the current HEAD.
+func (s *CentralServer) planRollout(spec RolloutSpec) (Rollout, error) {
+	spec.Files = slices.DeleteFunc(spec.Files, func(p string) bool {
+		return p == ""
+	})
+	if len(spec.Files) == 0 {
+		return Rollout{}, fmt.Errorf("rollout needs at least one file")
+	}
+	for _, p := range spec.Files {
+		if !filepath.IsLocal(p) {
+			return Rollout{}, fmt.Errorf("%s is outside of the deployments repository", p)
+		}
+	}
+	switch spec.OnFailure {
+	case "":
+		spec.OnFailure = ROLLOUT_ON_FAILURE_HALT
+	case ROLLOUT_ON_FAILURE_HALT, ROLLOUT_ON_FAILURE_ROLLBACK:
+	default:
+		return Rollout{}, fmt.Errorf("unknown on_failure %q", spec.OnFailure)
+	}
+	if spec.HealthTimeout <= 0 {
+		spec.HealthTimeout = DEFAULT_ROLLOUT_HEALTH_TIMEOUT
+	}
+	if len(spec.Waves) == 0 {
+		spec.Waves = DEFAULT_ROLLOUT_WAVES
+	}
+
+	agents := []string{}
+	switch {
+	case len(spec.Agents) > 0:
+		for _, id := range spec.Agents {
+			if _, ok := s.agents.Get(id); !ok {
+				return Rollout{}, fmt.Errorf("agent %s is not registered", id)
+			}
+			if !slices.Contains(agents, id) {
+				agents = append(agents, id)
+			}
+		}
+	case spec.Selector != "":
+		selector, err := labels.Parse(spec.Selector)
+		if err != nil {
+			return Rollout{}, fmt.Errorf("invalid selector: %w", err)
+		}
+		matching := s.agents.Select(selector)
+		slices.SortFunc(matching, func(a, b AgentMetadata) int {
+			return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+		})
+		for _, agent := range matching {
+			agents = append(agents, agent.ID)
+		}
+	default:
+		return Rollout{}, fmt.Errorf("rollout needs agents or a selector")
+	}
+	if len(agents) == 0 {
+		return Rollout{}, fmt.Errorf("no agent matches %q", spec.Selector)
+	}
+
+	waves, err := planWaves(agents, spec.Waves)
+	if err != nil {
+		return Rollout{}, err
+	}
+	commit, err := DeploymentsHash(s.repo)
+	if err != nil {
+		return Rollout{}, err
+	}
+	if _, err := FilesAt(s.repo, commit, spec.Files); err != nil {
+		return Rollout{}, err
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return Rollout{}, fmt.Errorf("failed to generate UUID: %w", err)
+	}
+
+	now := time.Now().Unix()
+	rollout := Rollout{
+		ID:        id.String(),
+		Spec:      spec,
+		Commit:    commit,
+		Waves:     waves,
+		Status:    ROLLOUT_STATUS_RUNNING,
+		Agents:    map[string]*RolloutAgent{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for i, wave := range waves {
+		for _, agentID := range wave {
+			rollout.Agents[agentID] = &RolloutAgent{
+				Wave:   i,
+				Status: ROLLOUT_AGENT_PENDING,
+			}
+		}
+	}
+	return rollout, nil
+}
+
+// Runs the rollout in the background until it finishes, is aborted or
+// central shuts down.
+func (s *CentralServer) launchRollout(id string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.runnersMu.Lock()
+	if _, running := s.runners[id]; running {
+		s.runnersMu.Unlock()
+		cancel()
+		return
+	}
+	s.runners[id] = cancel
+	s.runnersMu.Unlock()
+
+	go func() {
+		defer func() {
+			s.runnersMu.Lock()
+			delete(s.runners, id)
+			s.runnersMu.Unlock()
+			cancel()
+		}()
+		s.runRollout(ctx, id)
+	}()
+}
+
+// Picks up the rollouts that were running when central stopped, the
+// running wave starts over.
+func (s *CentralServer) resumeRollouts(ctx context.Context) {
+	rollouts, err := s.agents.Rollouts(ctx)
+	if err != nil {
+		log.Error("Failed to load rollouts: ", err)
+		return
+	}
+	for _, rollout := range rollouts {
+		if rollout.Status == ROLLOUT_STATUS_RUNNING {
+			log.Infof("Resuming rollout %s at wave %d", rollout.ID, rollout.Wave+1)
+			s.launchRollout(rollout.ID)
+		}
+	}
+}
+
+// Cancels every running rollout, they stay running in etcd and are resumed
+// on the next start.
+func (s *CentralServer) stopRollouts() {
+	s.runnersMu.Lock()
+	defer s.runnersMu.Unlock()
+	for _, cancel := range s.runners {
+		cancel()
+	}
+}
+
+// Marks the rollout aborted and stops its runner. Agents are left as they
+// are.
+func (s *CentralServer) abortRollout(ctx context.Context, id string) (Rollout, error) {
+	rollout, err := s.agents.UpdateRollout(ctx, id, func(rollout *Rollout) {
+		if rollout.Status == ROLLOUT_STATUS_RUNNING {
+			rollout.Status = ROLLOUT_STATUS_ABORTED
+			rollout.UpdatedAt = time.Now().Unix()
+		}
+	})
+	if err != nil {
+		return rollout, err
+	}
+	s.runnersMu.Lock()
+	if cancel, ok := s.runners[id]; ok {
+		cancel()
+	}
+	s.runnersMu.Unlock()
+	log.Infof("Rollout %s aborted", id)
+	return rollout, nil
+}
+
+func (s *CentralServer) runRollout(ctx context.Context, id string) {
+	for {
+		rollout, err := s.agents.Rollout(ctx, id)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Errorf("Rollout %s stopped: %v", id, err)
+			}
+			return
+		}
+		if rollout.Status != ROLLOUT_STATUS_RUNNING {
+			return
+		}
+		if rollout.Wave >= len(rollout.Waves) {
+			log.Infof("Rollout %s succeeded", id)
+			s.finishRollout(ctx, id, ROLLOUT_STATUS_SUCCEEDED, nil)
+			return
+		}
+
+		log.Infof("Rollout %s: wave %d/%d on %v", id, rollout.Wave+1, len(rollout.Waves), rollout.Waves[rollout.Wave])
+		err = s.runWave(ctx, rollout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.failRollout(ctx, id, fmt.Errorf("wave %d failed: %w", rollout.Wave+1, err))
+			return
+		}
+		_, err = s.agents.UpdateRollout(ctx, id, func(rollout *Rollout) {
+			if rollout.Status == ROLLOUT_STATUS_RUNNING {
+				rollout.Wave++
+				rollout.UpdatedAt = time.Now().Unix()
+			}
+		})
+		if err != nil {
+			log.Errorf("Rollout %s stopped: %v", id, err)
+			return
+		}
+	}
+}
+
+// Applies the rollout on every agent of the running wave at once and waits
+// for them to become healthy.
+func (s *CentralServer) runWave(ctx context.Context, rollout Rollout) error {
+	wave := rollout.Waves[rollout.Wave]
+	errs := make([]error, len(wave))
+	var wg sync.WaitGroup
+	for i, agentID := range wave {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.rolloutAgent(ctx, rollout, agentID); err != nil {
+				errs[i] = fmt.Errorf("agent %s: %w", agentID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *CentralServer) rolloutAgent(ctx context.Context, rollout Rollout, agentID string) error {
+	state := rollout.Agents[agentID]
+	if state.Status == ROLLOUT_AGENT_HEALTHY {
+		// done before central restarted
+		return nil
+	}
+
+	if state.Previous == nil {
+		previous, err := s.previousFiles(ctx, rollout, agentID)
+		if err != nil {
+			s.updateRolloutAgent(ctx, rollout.ID, agentID, ROLLOUT_AGENT_FAILED, err, nil)
+			return err
+		}
+		s.updateRolloutAgent(ctx, rollout.ID, agentID, ROLLOUT_AGENT_PENDING, nil, func(state *RolloutAgent) {
+			state.Previous = previous
+		})
+	}
+
+	deployments, err := s.rolloutApply(ctx, rollout, agentID)
+	status := ROLLOUT_AGENT_PROGRESSING
+	if err != nil {
+		status = ROLLOUT_AGENT_FAILED
+	}
+	s.updateRolloutAgent(ctx, rollout.ID, agentID, status, err, func(state *RolloutAgent) {
+		state.Deployments = deployments
+	})
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(rollout.Spec.HealthTimeout) * time.Second
+	if err := s.waitHealthy(ctx, agentID, deployments, timeout); err != nil {
+		s.updateRolloutAgent(ctx, rollout.ID, agentID, ROLLOUT_AGENT_FAILED, err, nil)
+		return err
+	}
+	s.updateRolloutAgent(ctx, rollout.ID, agentID, ROLLOUT_AGENT_HEALTHY, nil, nil)
+	log.Infof("Rollout %s is healthy on agent %s", rollout.ID, agentID)
+	return nil
+}
+
+// Recorded files of the agent the rollout is about to overwrite.
+func (s *CentralServer) previousFiles(ctx context.Context, rollout Rollout, agentID string) (map[string]DesiredFile, error) {
+	desired, err := s.agents.Desired(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+	files, err := FilesAt(s.repo, rollout.Commit, rollout.Spec.Files)
+	if err != nil {
+		return nil, err
+	}
+	previous := map[string]DesiredFile{}
+	for name := range files {
+		if file, ok := desired.Files[name]; ok {
+			previous[name] = file
+		}
+	}
+	return previous, nil
+}
+
+// Applies the rollout's files at its commit, returning the applied
+// deployments as file -> namespace/name, also when some objects failed.
+func (s *CentralServer) rolloutApply(ctx context.Context, rollout Rollout, agentID string) (map[string][]string, error) {
+	objects, err := s.applyAt(ctx, agentID, rollout.Commit, rollout.Spec.Files, rollout.Spec.Namespace)
+	deployments := map[string][]string{}
+	for _, obj := range objects {
+		if obj.GetKind() == "Deployment" && obj.GetStatus() != pba.ObjectStatus_FAILED {
+			deployments[obj.GetFile()] = append(deployments[obj.GetFile()], obj.GetNamespace()+"/"+obj.GetName())
+		}
+	}
+	return deployments, err
+}
+
+// Ships the files at the commit to the agent and applies them, recording
+// the result. Fails unless every object was applied.
+func (s *CentralServer) applyAt(
+	ctx context.Context,
+	agentID string,
+	commit string,
+	files []string,
+	namespace string,
+) ([]*pba.ObjectResult, error) {
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach agent: %w", err)
+	}
+	req := &pba.ApplyDeploymentsRequest{
+		DeploymentName: files,
+		Namespace:      proto.String(namespace),
+	}
+	var resp *pba.ApplyDeploymentsResponse
+	err = s.withBundleAt(commit, files, func(bundle *pba.Bundle, hash string, commit string) error {
+		req.Bundle = bundle
+		req.BundleHash = proto.String(hash)
+		req.Commit = proto.String(commit)
+		resp, err = client.ApplyDeployments(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %v: %w", files, err)
+	}
+	s.recordApplied(ctx, agentID, namespace, resp.GetCommit(), req.GetBundleHash(), resp.GetObjects())
+
+	report := newObjectsReport(resp.GetSuccess(), resp.GetObjects())
+	if failed := report.failed(); !resp.GetSuccess() || len(failed) > 0 {
+		return resp.GetObjects(), fmt.Errorf("%d of %d objects failed: %s",
+			len(failed), len(report.Objects), strings.TrimSpace(report.failureMessage()))
+	}
+	return resp.GetObjects(), nil
+}
+
+// Ready once the agent reports the latest generation and every replica
+// updated, ready and available.
+func deploymentHealthy(deployment *pba.DeploymentMetadata) bool {
+	replicas := deployment.GetReplicas()
+	return deployment.GetObservedGeneration() >= deployment.GetGeneration() &&
+		deployment.GetUpdatedReplicas() >= replicas &&
+		deployment.GetReadyReplicas() >= replicas &&
+		deployment.GetAvailableReplicas() >= replicas
+}
+
+// Polls the agent's deployments until all of them are healthy or the
+// timeout runs out.
+func (s *CentralServer) waitHealthy(
+	ctx context.Context,
+	agentID string,
+	deployments map[string][]string,
+	timeout time.Duration,
+) error {
+	wanted := slices.Sorted(slices.Values(slices.Concat(slices.Collect(maps.Values(deployments))...)))
+	if len(wanted) == 0 {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		unhealthy, err := s.unhealthyDeployments(ctx, agentID, wanted)
+		if err == nil && len(unhealthy) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("health check failed: %w", err)
+			}
+			return fmt.Errorf("not healthy after %s: %s", timeout, strings.Join(unhealthy, ", "))
+		}
+		if err != nil {
+			log.Warnf("Health check on agent %s failed, retrying: %v", agentID, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(ROLLOUT_HEALTH_INTERVAL * time.Second):
+		}
+	}
+}
+
+// The wanted namespace/name deployments that are missing or not healthy
+// yet, with their replica counts.
+func (s *CentralServer) unhealthyDeployments(ctx context.Context, agentID string, wanted []string) ([]string, error) {
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.ListDeployments(ctx, &pba.ListDeploymentsRequest{
+		AllNamespaces: proto.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	live := map[string]*pba.DeploymentMetadata{}
+	for _, deployment := range resp.GetDeployments() {
+		live[deployment.GetNamespace()+"/"+deployment.GetName()] = deployment
+	}
+
+	unhealthy := []string{}
+	for _, key := range wanted {
+		deployment, ok := live[key]
+		switch {
+		case !ok:
+			unhealthy = append(unhealthy, key+" (missing)")
+		case !deploymentHealthy(deployment):
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (ready %d/%d, updated %d/%d)",
+				key,
+				deployment.GetReadyReplicas(), deployment.GetReplicas(),
+				deployment.GetUpdatedReplicas(), deployment.GetReplicas(),
+			))
+		}
+	}
+	return unhealthy, nil
+}
+
+// Halts the rollout, or rolls back every agent it reached first when the
+// spec asks for it.
+func (s *CentralServer) failRollout(ctx context.Context, id string, cause error) {
+	log.Errorf("Rollout %s: %v", id, cause)
+	rollout, err := s.agents.Rollout(ctx, id)
+	if err != nil {
+		log.Errorf("Rollout %s stopped: %v", id, err)
+		return
+	}
+	if rollout.Spec.OnFailure != ROLLOUT_ON_FAILURE_ROLLBACK {
+		s.finishRollout(ctx, id, ROLLOUT_STATUS_HALTED, cause)
+		return
+	}
+
+	errs := []error{cause}
+	for _, agentID := range slices.Sorted(maps.Keys(rollout.Agents)) {
+		state := rollout.Agents[agentID]
+		if state.Status == ROLLOUT_AGENT_PENDING {
+			continue
+		}
+		if state.Previous == nil {
+			// failed before its recorded files were taken, nothing was
+			// applied and a prune would delete the objects it had before
+			log.Infof("Rollout %s: nothing to roll back on agent %s", id, agentID)
+			continue
+		}
+		log.Infof("Rollout %s: rolling back agent %s", id, agentID)
+		if err := s.rollbackAgent(ctx, rollout, agentID, state); err != nil {
+			err = fmt.Errorf("rollback of agent %s failed: %w", agentID, err)
+			s.updateRolloutAgent(ctx, id, agentID, ROLLOUT_AGENT_FAILED, err, nil)
+			errs = append(errs, err)
+			continue
+		}
+		s.updateRolloutAgent(ctx, id, agentID, ROLLOUT_AGENT_ROLLED_BACK, nil, nil)
+	}
+	if len(errs) > 1 {
+		s.finishRollout(ctx, id, ROLLOUT_STATUS_FAILED, errors.Join(errs...))
+		return
+	}
+	s.finishRollout(ctx, id, ROLLOUT_STATUS_ROLLED_BACK, cause)
+}
+
+// Applies the files the rollout overwrote again at the commit they were at,
+// then deletes every object still labeled with the rollout's commit: those
+// of files new to the agent and those added to the overwritten files.
+func (s *CentralServer) rollbackAgent(ctx context.Context, rollout Rollout, agentID string, state *RolloutAgent) error {
+	type appliedAt struct {
+		commit    string
+		namespace string
+	}
+	groups := map[appliedAt][]string{}
+	// files that were already at the rollout's commit, or failed to be
+	// restored, their objects stay
+	keep := []string{}
+	for name, file := range state.Previous {
+		if file.Commit == rollout.Commit {
+			keep = append(keep, name)
+			continue
+		}
+		at := appliedAt{commit: file.Commit, namespace: file.Namespace}
+		groups[at] = append(groups[at], name)
+	}
+	errs := []error{}
+	for at, files := range groups {
+		slices.Sort(files)
+		if _, err := s.applyAt(ctx, agentID, at.commit, files, at.namespace); err != nil {
+			errs = append(errs, err)
+			keep = append(keep, files...)
+		}
+	}
+
+	client, err := s.agentClient(agentID)
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("failed to reach agent: %w", err))...)
+	}
+	resp, err := client.PruneApplied(ctx, &pba.PruneAppliedRequest{
+		Paths:  rollout.Spec.Files,
+		Commit: proto.String(rollout.Commit),
+		Keep:   keep,
+	})
+	if err != nil {
+		return errors.Join(append(errs, fmt.Errorf("failed to prune %v at %s: %w", rollout.Spec.Files, rollout.Commit, err))...)
+	}
+	s.recordRemoved(ctx, agentID, resp.GetObjects())
+	if !resp.GetSuccess() {
+		report := newObjectsReport(resp.GetSuccess(), resp.GetObjects())
+		errs = append(errs, fmt.Errorf("failed to prune %v: %s", rollout.Spec.Files, strings.TrimSpace(report.failureMessage())))
+	}
+	return errors.Join(errs...)
+}
+
+// Sets the status of an agent of a running rollout, and applies fn to it
+// if given.
+func (s *CentralServer) updateRolloutAgent(
+	ctx context.Context,
+	id string,
+	agentID string,
+	status string,
+	agentErr error,
+	fn func(state *RolloutAgent),
+) {
+	_, err := s.agents.UpdateRollout(ctx, id, func(rollout *Rollout) {
+		state, ok := rollout.Agents[agentID]
+		if rollout.Status != ROLLOUT_STATUS_RUNNING || !ok {
+			return
+		}
+		state.Status = status
+		state.Error = ""
+		if agentErr != nil {
+			state.Error = agentErr.Error()
+		}
+		if fn != nil {
+			fn(state)
+		}
+		rollout.UpdatedAt = time.Now().Unix()
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Errorf("Failed to update agent %s of rollout %s: %v", agentID, id, err)
+	}
+}
+
+func (s *CentralServer) finishRollout(ctx context.Context, id string, status string, cause error) {
+	_, err := s.agents.UpdateRollout(ctx, id, func(rollout *Rollout) {
+		if rollout.Status != ROLLOUT_STATUS_RUNNING {
+			return
+		}
+		rollout.Status = status
+		if cause != nil {
+			rollout.Error = cause.Error()
+		}
+		rollout.UpdatedAt = time.Now().Unix()
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Errorf("Failed to finish rollout %s: %v", id, err)
+	}
+}
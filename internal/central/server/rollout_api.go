@@ -0,0 +1,119 @@
+// progressive rollouts across agents, look for "rollout.go" for how they run
+// and "rollout_page.go" for the htmx integration.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	mux "github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+	. "github.com/Coosis/go-k8s-cord/internal/central/registry"
+)
+
+const (
+	ROLLOUTS_PATH      = "/api/v1/rollouts"
+	ROLLOUT_PATH       = "/api/v1/rollouts/{rollout_id}"
+	ROLLOUT_ABORT_PATH = "/api/v1/rollouts/{rollout_id}/abort"
+)
+
+func (s *CentralServer) setupRolloutRoutes() {
+	s.HandleFunc(ROLLOUTS_PATH, s.rollouts)
+	s.HandleFunc(ROLLOUT_PATH, s.rollout)
+	s.HandleFunc(ROLLOUT_ABORT_PATH, s.rolloutAbort)
+}
+
+// GET lists the rollouts, the most recent first. POST a RolloutSpec starts
+// a new rollout at the current HEAD.
+func (s *CentralServer) rollouts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rollouts, err := s.agents.Rollouts(r.Context())
+		if err != nil {
+			log.Error("Failed to list rollouts: ", err)
+			http.Error(w, "Failed to list rollouts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(rollouts); err != nil {
+			log.Error("Failed to encode rollouts: ", err)
+			http.Error(w, "Failed to encode rollouts", http.StatusInternalServerError)
+			return
+		}
+	case http.MethodPost:
+		var spec RolloutSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			log.Error("Failed to decode rollout spec: ", err)
+			http.Error(w, "Failed to decode rollout spec: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		rollout, err := s.planRollout(spec)
+		if err != nil {
+			log.Warn("Rejected rollout: ", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.agents.CreateRollout(r.Context(), rollout); err != nil {
+			log.Error("Failed to create rollout: ", err)
+			http.Error(w, "Failed to create rollout: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Infof("Rollout %s of %v at %s started, %d waves", rollout.ID, rollout.Spec.Files, rollout.Commit, len(rollout.Waves))
+		s.launchRollout(rollout.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(rollout); err != nil {
+			log.Error("Failed to encode rollout: ", err)
+			return
+		}
+	default:
+		log.Warn("Method not allowed for rollouts endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *CentralServer) rollout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Warn("Method not allowed for rollout endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := mux.Vars(r)["rollout_id"]
+	rollout, err := s.agents.Rollout(r.Context(), id)
+	s.writeRollout(w, id, rollout, err)
+}
+
+// Stops a running rollout where it is, finished rollouts are left alone.
+func (s *CentralServer) rolloutAbort(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn("Method not allowed for rollout abort endpoint")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := mux.Vars(r)["rollout_id"]
+	rollout, err := s.abortRollout(r.Context(), id)
+	s.writeRollout(w, id, rollout, err)
+}
+
+func (s *CentralServer) writeRollout(w http.ResponseWriter, id string, rollout Rollout, err error) {
+	if errors.Is(err, ErrRolloutNotFound) {
+		log.Warnf("Rollout %s not found", id)
+		http.Error(w, "Rollout not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to load rollout %s: %v", id, err)
+		http.Error(w, "Failed to load rollout: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rollout); err != nil {
+		log.Errorf("Failed to encode rollout %s: %v", id, err)
+		http.Error(w, "Failed to encode rollout", http.StatusInternalServerError)
+		return
+	}
+}
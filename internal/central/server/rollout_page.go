@@ -0,0 +1,182 @@
+// rollouts page, look for "rollout_api.go" for the API implementation.
+// For html look for rollouts.html and rollouts_list.html in templates directory.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	mux "github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
+)
+
+const (
+	ROLLOUTS_URL      = "http://localhost%s" + ROLLOUTS_PATH
+	ROLLOUT_ABORT_URL = "http://localhost%s/api/v1/rollouts/%s/abort"
+
+	PAGE_ROLLOUTS_PATH = "/rollouts"
+)
+
+// Agents as returned by the list agents endpoint.
+func getAgentList(port string) ([]map[string]string, error) {
+	resp, err := http.Get(fmt.Sprintf(agentListEndpoint, port))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %s", resp.Status)
+	}
+	var agents []map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&agents); err != nil {
+		return nil, err
+	}
+	return agents, nil
+}
+
+func (s *CentralServer) setupRolloutsHTML() {
+	cfg := GetCentralConfig()
+	rolloutsTemplate := template.Must(template.ParseFiles(
+		"templates/base.html",
+		"templates/rollouts.html",
+	))
+	s.HandleFunc(PAGE_ROLLOUTS_PATH, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			agents, err := getAgentList(cfg.HTTPSPort)
+			if err != nil {
+				log.Error("Failed to get agent list: ", err)
+				http.Error(w, "Failed to get agent list: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			htmlVars := map[string]any{
+				"Agents": agents,
+			}
+			if err := rolloutsTemplate.Execute(w, htmlVars); err != nil {
+				log.Error("Failed to execute rollouts template: ", err)
+				http.Error(w, "Failed to execute rollouts template: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				log.Error("Failed to parse form data: ", err)
+				http.Error(w, "Failed to parse form data: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			spec := RolloutSpec{
+				Files:     strings.Fields(r.Form.Get("files")),
+				Namespace: r.Form.Get("namespace"),
+				Agents:    r.Form["agents"],
+				Selector:  strings.TrimSpace(r.Form.Get("selector")),
+				Waves:     strings.Fields(r.Form.Get("waves")),
+				OnFailure: r.Form.Get("on_failure"),
+			}
+			if timeout := r.Form.Get("health_timeout"); timeout != "" {
+				seconds, err := strconv.ParseInt(timeout, 10, 64)
+				if err != nil {
+					http.Error(w, "Invalid health timeout: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				spec.HealthTimeout = seconds
+			}
+			jsonBody, err := json.Marshal(spec)
+			if err != nil {
+				log.Error("Failed to marshal rollout spec: ", err)
+				http.Error(w, "Failed to marshal rollout spec: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			resp, err := http.Post(fmt.Sprintf(ROLLOUTS_URL, cfg.HTTPSPort), "application/json", bytes.NewReader(jsonBody))
+			if err != nil {
+				log.Error("Failed to start rollout: ", err)
+				http.Error(w, "Failed to start rollout: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated {
+				bodyBytes, _ := io.ReadAll(resp.Body)
+				log.Error("Failed to start rollout, status code: ", resp.StatusCode)
+				http.Error(w, "Failed to start rollout: "+string(bodyBytes), resp.StatusCode)
+				return
+			}
+			http.Redirect(w, r, PAGE_ROLLOUTS_PATH, http.StatusSeeOther)
+		default:
+			log.Warn("Method not allowed for rollouts page")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	rolloutsListTemplate := template.Must(template.ParseFiles("templates/rollouts_list.html"))
+	s.HandleFunc("/rollouts/list", func(w http.ResponseWriter, r *http.Request) {
+		resp, err := http.Get(fmt.Sprintf(ROLLOUTS_URL, cfg.HTTPSPort))
+		if err != nil {
+			log.Error("Failed to list rollouts: ", err)
+			http.Error(w, "Failed to list rollouts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Error("Failed to list rollouts, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to list rollouts, status code: "+resp.Status, resp.StatusCode)
+			return
+		}
+		var rollouts []Rollout
+		if err := json.NewDecoder(resp.Body).Decode(&rollouts); err != nil {
+			log.Error("Failed to decode rollouts: ", err)
+			http.Error(w, "Failed to decode rollouts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		agents, err := getAgentList(cfg.HTTPSPort)
+		if err != nil {
+			log.Error("Failed to get agent list: ", err)
+			http.Error(w, "Failed to get agent list: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		names := map[string]string{}
+		for _, agent := range agents {
+			names[agent["id"]] = agent["name"]
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		htmlVars := map[string]any{
+			"Rollouts": rollouts,
+			"Names":    names,
+		}
+		if err := rolloutsListTemplate.Execute(w, htmlVars); err != nil {
+			log.Error("Failed to execute rollouts list template: ", err)
+			http.Error(w, "Failed to execute rollouts list template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	s.HandleFunc("/rollouts/{rollout_id}/abort", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			log.Warn("Method not allowed for rollout abort page endpoint")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := mux.Vars(r)["rollout_id"]
+		resp, err := http.Post(fmt.Sprintf(ROLLOUT_ABORT_URL, cfg.HTTPSPort, id), "application/json", nil)
+		if err != nil {
+			log.Error("Failed to abort rollout: ", err)
+			http.Error(w, "Failed to abort rollout: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			log.Error("Failed to abort rollout, status code: ", resp.StatusCode)
+			http.Error(w, "Failed to abort rollout: "+string(bodyBytes), resp.StatusCode)
+			return
+		}
+		http.Redirect(w, r, PAGE_ROLLOUTS_PATH, http.StatusSeeOther)
+	})
+}
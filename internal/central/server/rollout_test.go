@@ -0,0 +1,121 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+
+	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
+)
+
+func TestPlanWaves(t *testing.T) {
+	agents := func(n int) []string {
+		names := []string{}
+		for i := range n {
+			names = append(names, string(rune('a'+i)))
+		}
+		return names
+	}
+	tests := []struct {
+		name   string
+		agents []string
+		sizes  []string
+		want   [][]string
+	}{
+		{
+			name:   "no sizes",
+			agents: agents(3),
+			want:   [][]string{{"a", "b", "c"}},
+		},
+		{
+			// 2.5 agents
+			name:   "percent rounds up",
+			agents: agents(10),
+			sizes:  []string{"25%"},
+			want:   [][]string{{"a", "b", "c"}, {"d", "e", "f", "g", "h", "i", "j"}},
+		},
+		{
+			name:   "small percent takes one agent",
+			agents: agents(3),
+			sizes:  []string{"1%", "50%"},
+			want:   [][]string{{"a"}, {"b", "c"}},
+		},
+		{
+			name:   "percent of every agent, not of the rest",
+			agents: agents(4),
+			sizes:  []string{"50%", "50%"},
+			want:   [][]string{{"a", "b"}, {"c", "d"}},
+		},
+		{
+			name:   "size larger than the remaining agents",
+			agents: agents(3),
+			sizes:  []string{"2", "5"},
+			want:   [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name:   "empty remainder",
+			agents: agents(4),
+			sizes:  []string{"2", "2"},
+			want:   [][]string{{"a", "b"}, {"c", "d"}},
+		},
+		{
+			name:   "sizes past the last agent",
+			agents: agents(2),
+			sizes:  []string{"2", "1", "100%"},
+			want:   [][]string{{"a", "b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planWaves(tt.agents, tt.sizes)
+			if err != nil {
+				t.Fatalf("planWaves() failed: %v", err)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Fatalf("planWaves() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanWavesInvalidSizes(t *testing.T) {
+	for _, size := range []string{"0", "-1", "0%", "101%", "half", "%"} {
+		if waves, err := planWaves([]string{"a", "b"}, []string{size}); err == nil {
+			t.Fatalf("wave size %q was accepted: %v", size, waves)
+		}
+	}
+}
+
+func TestDeploymentHealthy(t *testing.T) {
+	deployment := func(replicas, ready, available, updated int32, generation, observed int64) *pba.DeploymentMetadata {
+		return &pba.DeploymentMetadata{
+			Replicas:           proto.Int32(replicas),
+			ReadyReplicas:      proto.Int32(ready),
+			AvailableReplicas:  proto.Int32(available),
+			UpdatedReplicas:    proto.Int32(updated),
+			Generation:         proto.Int64(generation),
+			ObservedGeneration: proto.Int64(observed),
+		}
+	}
+	tests := []struct {
+		name       string
+		deployment *pba.DeploymentMetadata
+		want       bool
+	}{
+		{name: "healthy", deployment: deployment(3, 3, 3, 3, 2, 2), want: true},
+		{name: "scaled to zero", deployment: deployment(0, 0, 0, 0, 1, 1), want: true},
+		{name: "surge replicas", deployment: deployment(3, 4, 4, 3, 1, 1), want: true},
+		{name: "spec not observed", deployment: deployment(3, 3, 3, 3, 3, 2), want: false},
+		{name: "not updated", deployment: deployment(3, 3, 3, 2, 2, 2), want: false},
+		{name: "not ready", deployment: deployment(3, 2, 3, 3, 2, 2), want: false},
+		{name: "not available", deployment: deployment(3, 3, 2, 3, 2, 2), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deploymentHealthy(tt.deployment); got != tt.want {
+				t.Fatalf("deploymentHealthy() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
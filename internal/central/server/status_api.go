@@ -3,16 +3,16 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"encoding/json"
-	"strconv"
-	"time"
-
-	. "github.com/Coosis/go-k8s-cord/internal/central/model"
 
+	"github.com/gogo/protobuf/proto"
 	pba "github.com/Coosis/go-k8s-cord/internal/pb/agent/v1"
 	log "github.com/sirupsen/logrus"
+
+	. "github.com/Coosis/go-k8s-cord/internal/central/model"
 )
 
 
@@ -24,33 +24,13 @@ func(s *CentralServer) setupStatusRoutes() {
 }
 
 func(s *CentralServer) GetStatus(w http.ResponseWriter, r *http.Request) {
-	cfg := GetCentralConfig()
-	w.Header().Set("Content-Type", "application/json")
 	status := make(map[string]string)
-	for agent_id, agent := range s.agents {
-		log.Debugf("Checking status for agent %s", agent_id)
-		resp, err := s.etcd.Get(r.Context(), agent_id)
-		name := agent.Name
-		if err != nil {
-			// w.Write(fmt.Appendf([]byte{}, "%v: %v\n", name, "offline"))
-			continue
-		}
-		var mx int64
-		mx = 0
-		for _, kv := range resp.Kvs {
-			cval, err := strconv.ParseInt(string(kv.Value), 10, 64)
-			if err != nil {
-				// w.Write(fmt.Appendf([]byte{}, "%v: %v\n", name, "offline"))
-				continue
-			}
-			mx = max(mx, cval)
-		}
-
-		valid := time.Now().Add(time.Second * -time.Duration(cfg.AliveInterval)).Unix()
-		if mx < valid {
-			status[name] = "offline"
+	for _, agent := range s.agents.List() {
+		log.Debugf("Checking status for agent %s", agent.ID)
+		if agent.Online {
+			status[agent.Name] = "online"
 		} else {
-			status[name] = "online"
+			status[agent.Name] = "offline"
 		}
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -60,23 +40,38 @@ func(s *CentralServer) GetStatus(w http.ResponseWriter, r *http.Request) {
 
 
 func(s *CentralServer) ListPods(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	var buf []byte
-	for agent_id, agent := range s.agents {
-		log.Debugf("Pods for agent %s", agent_id)
-		name := agent.Name
-
-		client := pba.NewAgentServiceClient(agent.AgentConn)
-		resp, err := client.ListPods(ctx, &pba.ListPodsRequest{ })
+	namespace, all := namespaceQuery(r)
+	opts, err := fanOutOptionsFrom(r)
+	if err != nil {
+		log.Warn("Invalid fan-out options: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	agents := s.agents.List()
+	results := s.fanOut(r.Context(), agents, opts, func(
+		ctx context.Context,
+		client pba.AgentServiceClient,
+		agent AgentMetadata,
+	) (any, error) {
+		resp, err := client.ListPods(ctx, &pba.ListPodsRequest{
+			Namespace:     proto.String(namespace),
+			AllNamespaces: proto.Bool(all),
+		})
 		if err != nil {
-			buf = append(buf, fmt.Sprintf("%s: %v\n", name, "error listing pods")...)
-			log.Errorf("Failed to list pods for agent %s: %v", name, err)
-			continue
+			return nil, err
 		}
+		return resp.GetPods(), nil
+	})
 
-		pods := resp.GetPods()
-		for _, pod := range pods {
-			buf = append(buf, fmt.Sprintf("%s: %s/%s\n", name, pod.GetNamespace(), pod.GetName())...)
+	var buf []byte
+	for _, agent := range agents {
+		result := results[agent.ID]
+		if result.Error != "" {
+			buf = append(buf, fmt.Sprintf("%s: %v\n", agent.Name, "error listing pods")...)
+			continue
+		}
+		for _, pod := range result.Result.([]*pba.PodMetadata) {
+			buf = append(buf, fmt.Sprintf("%s: %s/%s\n", agent.Name, pod.GetNamespace(), pod.GetName())...)
 		}
 	}
 
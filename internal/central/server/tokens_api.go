@@ -0,0 +1,71 @@
+// Bootstrap token api, tokens are handed to agents out of band and used
+// once in RegisterAgent.
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TOKENS_PATH = "/api/v1/tokens"
+
+	DEFAULT_TOKEN_TTL = time.Hour
+)
+
+func(s *CentralServer) setupTokenRoutes() {
+	s.HandleFunc(TOKENS_PATH, s.CreateToken)
+}
+
+type createTokenPayload struct {
+	// duration string such as "30m", defaults to DEFAULT_TOKEN_TTL
+	TTL string `json:"ttl"`
+}
+
+func(s *CentralServer) CreateToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Warn("CreateToken called with method: ", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload createTokenPayload
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			log.Error("Failed to decode token request: ", err)
+			http.Error(w, "Failed to decode token request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	ttl := DEFAULT_TOKEN_TTL
+	if payload.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(payload.TTL)
+		if err != nil {
+			log.Error("Invalid token ttl: ", err)
+			http.Error(w, "Invalid token ttl: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	token, expiresAt, err := s.agents.CreateToken(r.Context(), ttl)
+	if err != nil {
+		log.Error("Failed to create token: ", err)
+		http.Error(w, "Failed to create token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]any{
+		"token":      token,
+		"expires_at": expiresAt.Unix(),
+	})
+	if err != nil {
+		log.Error("Failed to encode token: ", err)
+		http.Error(w, "Failed to encode token", http.StatusInternalServerError)
+		return
+	}
+}
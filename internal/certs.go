@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/// Serves a certificate/key pair from disk, reloading it whenever the
+/// certificate file changes. Plug GetCertificate/GetClientCertificate
+/// into a tls.Config instead of loading the pair once.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+/// A missing certificate is not an error, nothing is served until it shows up.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) reload() error {
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && info.ModTime().Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", r.certFile, err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = info.ModTime()
+	r.mu.Unlock()
+	log.Infof("Loaded certificate %s", r.certFile)
+	return nil
+}
+
+/// Current certificate, nil if none has been loaded yet.
+func (r *CertReloader) Certificate() *tls.Certificate {
+	if err := r.reload(); err != nil && !os.IsNotExist(err) {
+		// keep serving the previous certificate
+		log.Error("Failed to reload certificate: ", err)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+/// Parsed leaf of the current certificate, nil if none has been loaded yet.
+func (r *CertReloader) Leaf() *x509.Certificate {
+	cert := r.Certificate()
+	if cert == nil {
+		return nil
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := r.Certificate()
+	if cert == nil {
+		return nil, fmt.Errorf("no certificate loaded from %s", r.certFile)
+	}
+	return cert, nil
+}
+
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert := r.Certificate()
+	if cert == nil {
+		// sending no certificate, e.g. before enrollment
+		return &tls.Certificate{}, nil
+	}
+	return cert, nil
+}
+
+/// Generates an ECDSA key and a CSR for it, both PEM encoded.
+/// hosts end up as SANs, IPs and DNS names alike.
+func GenerateKeyAndCSR(commonName string, hosts []string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CSR: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	return keyPEM, csrPEM, nil
+}
+
+/// Replaces a certificate/key pair on disk. The key goes first so that a
+/// CertReloader never sees the new certificate next to the old key.
+func WriteKeyPair(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	if err := writeFileAtomic(keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	return writeFileAtomic(certFile, certPEM, 0o644)
+}
+
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", name, err)
+	}
+	return nil
+}
@@ -1,7 +1,9 @@
 package internal
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"os"
 
 	log "github.com/sirupsen/logrus"
@@ -18,3 +20,9 @@ func LoadCAPool(certname string) (*x509.CertPool, error) {
 	cas.AppendCertsFromPEM(caCert)
 	return cas, nil
 }
+
+/// SHA-256 fingerprint of a certificate, hex encoded
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}